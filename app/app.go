@@ -3,6 +3,7 @@ package app
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -32,6 +33,37 @@ type Configuration struct {
 		PDFDir      string `yaml:"pdf_dir,omitempty"`
 	} `yaml:"paths,omitempty"`
 
+	Queue struct {
+		Capacity int    `yaml:"capacity,omitempty"`
+		Overflow string `yaml:"overflow,omitempty"`
+	} `yaml:"queue,omitempty"`
+
+	Watcher struct {
+		Kind         string        `yaml:"kind,omitempty"`
+		PollInterval time.Duration `yaml:"poll_interval,omitempty"`
+	} `yaml:"watcher,omitempty"`
+
+	Retention struct {
+		MaxAge       time.Duration `yaml:"max_age,omitempty"`
+		FailedMaxAge time.Duration `yaml:"failed_max_age,omitempty"`
+		MaxTotalSize int64         `yaml:"max_total_size,omitempty"`
+		KeepLast     int           `yaml:"keep_last,omitempty"`
+		Interval     time.Duration `yaml:"interval,omitempty"`
+	} `yaml:"retention,omitempty"`
+
+	LPD struct {
+		Enable  bool              `yaml:"enable,omitempty"`
+		Address string            `yaml:"address,omitempty"`
+		Queues  map[string]string `yaml:"queues,omitempty"`
+	} `yaml:"lpd,omitempty"`
+
+	IPP struct {
+		Enable  bool   `yaml:"enable,omitempty"`
+		Address string `yaml:"address,omitempty"`
+	} `yaml:"ipp,omitempty"`
+
+	Classification ClassificationConfig `yaml:"classification,omitempty"`
+
 	Devices map[string]DeviceConfig `yaml:"devices,omitempty"`
 
 	Printers map[string]PrinterConfig `yaml:"printers,omitempty"`
@@ -45,24 +77,105 @@ func (config *Configuration) Printer(name string) *PrinterConfig {
 	}
 }
 
+// Special targets of a device besides the installed printers
+const (
+	TargetPDF    = "PDF"            // only create a PDF file
+	TargetChoose = "Drucker wählen" // ask for the printer
+)
+
 type DeviceConfig struct {
-	Pos           int               `yaml:"pos,omitempty"`
-	Device        string            `yaml:"device,omitempty"`
-	Name          string            `yaml:"name,omitempty"`
-	File          string            `yaml:"file,omitempty"`
-	Timeout       time.Duration     `yaml:"timeout,omitempty"`
-	Target        string            `yaml:"target,omitempty"`
-	ExtendTimeout bool              `yaml:"extend_timeout,omitempty"`
-	PrintViaPDF   bool              `yaml:"print_via_pdf,omitempty"`
-	JobConfigs    map[string]string `yaml:"job_configs,omitempty"`
+	Pos                int                   `yaml:"pos,omitempty"`
+	Device             string                `yaml:"device,omitempty"`
+	Name               string                `yaml:"name,omitempty"`
+	File               string                `yaml:"file,omitempty"`
+	Address            string                `yaml:"address,omitempty"`
+	Port               string                `yaml:"port,omitempty"` // default, fifo or pty, ignored for network and serial devices
+	Serial             *SerialConfig         `yaml:"serial,omitempty"`
+	Timeout            time.Duration         `yaml:"timeout,omitempty"`
+	Completion         []string              `yaml:"completion,omitempty"`
+	CompletionFallback time.Duration         `yaml:"completion_fallback,omitempty"`
+	Validation         string                `yaml:"validation,omitempty"`
+	Target             string                `yaml:"target,omitempty"`
+	ExtendTimeout      bool                  `yaml:"extend_timeout,omitempty"`
+	ExtendedTimeout    time.Duration         `yaml:"extended_timeout,omitempty"`
+	AdaptiveTimeout    bool                  `yaml:"adaptive_timeout,omitempty"`
+	PrintViaPDF        bool                  `yaml:"print_via_pdf,omitempty"`
+	SplitJobs          bool                  `yaml:"split_jobs,omitempty"`
+	SplitAtReset       bool                  `yaml:"split_at_reset,omitempty"`
+	Hold               bool                  `yaml:"hold,omitempty"`
+	Duplicates         *DuplicateConfig      `yaml:"duplicates,omitempty"`
+	Classification     *ClassificationConfig `yaml:"classification,omitempty"`
+	JobConfigs         map[string]string     `yaml:"job_configs,omitempty"`
+}
+
+// DuplicateConfig configures how a device deals with jobs that were printed twice by accident.
+type DuplicateConfig struct {
+	Window    time.Duration `yaml:"window,omitempty"`
+	Action    string        `yaml:"action,omitempty"`    // hold or drop
+	Sanitized bool          `yaml:"sanitized,omitempty"` // ignore PJL commands when comparing jobs
+}
+
+// ClassificationConfig configures how jobs are classified as lists or forms. The rules of a device
+// are checked before the global ones, and the first matching rule wins.
+type ClassificationConfig struct {
+	Default string               `yaml:"default,omitempty"` // list or form
+	Rules   []ClassificationRule `yaml:"rules,omitempty"`
+}
+
+// ClassificationRule matches jobs by their features, conditions that are not set are ignored.
+type ClassificationRule struct {
+	Name                string `yaml:"name,omitempty"`
+	Type                string `yaml:"type,omitempty"` // list or form
+	AbsolutePositioning *bool  `yaml:"absolute_positioning,omitempty"`
+	Landscape           *bool  `yaml:"landscape,omitempty"`
+	Macros              *bool  `yaml:"macros,omitempty"`
+	Graphics            *bool  `yaml:"graphics,omitempty"`
+	MinLineWidth        int    `yaml:"min_line_width,omitempty"`
+	MaxLineWidth        int    `yaml:"max_line_width,omitempty"`
+	PageSizes           []int  `yaml:"page_sizes,omitempty"` // PCL page size codes, e.g. 26 for A4
+	Pattern             string `yaml:"pattern,omitempty"`    // regular expression for the text of the job
+}
+
+// SerialConfig configures a device that captures from a serial port.
+type SerialConfig struct {
+	Port     string `yaml:"port,omitempty"`
+	Baud     int    `yaml:"baud,omitempty"`
+	DataBits int    `yaml:"data_bits,omitempty"`
+	Parity   string `yaml:"parity,omitempty"`
+	StopBits int    `yaml:"stop_bits,omitempty"`
+	XonXoff  bool   `yaml:"xon_xoff,omitempty"`
 }
 
 type PrinterConfig struct {
 	Name       string               `yaml:"name,omitempty"`
 	DefaultJob string               `yaml:"default_job,omitempty"`
+	PostScript bool                 `yaml:"postscript,omitempty"` // the printer accepts PostScript
 	Jobs       map[string]JobConfig `yaml:"jobs,omitempty"`
 }
 
+// Job returns the job config with the given name. If there is no such job config, it falls back to
+// the default job and then to the first job config.
+func (pc *PrinterConfig) Job(name string) (JobConfig, bool) {
+	if len(pc.Jobs) == 0 {
+		return JobConfig{}, false
+	}
+	jobs := make([]JobConfig, 0, len(pc.Jobs))
+	for _, job := range pc.Jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Pos < jobs[j].Pos
+	})
+	for _, candidate := range []string{name, pc.DefaultJob} {
+		for _, job := range jobs {
+			if candidate != "" && strings.EqualFold(job.Name, candidate) {
+				return job, true
+			}
+		}
+	}
+	return jobs[0], true
+}
+
 type JobConfig struct {
 	Pos              int    `yaml:"pos,omitempty"`
 	Name             string `yaml:"name,omitempty"`
@@ -77,6 +190,8 @@ var backgroundCtx context.Context
 var ctx context.Context
 var cancel context.CancelFunc
 var cr *config.ConfigRepository
+var reloadHooks []func()
+var reloadHooksMutex sync.Mutex
 
 func init() {
 	backgroundCtx = context.Background()
@@ -109,18 +224,37 @@ func LoadConfig(userConfigFile string, configFiles ...string) error {
 
 func ReloadConfig() error {
 	err := cr.Load()
-	config := Config()
-	config.Lock()
-	defer config.Unlock()
-	if config.Logging.Level < log.ErrorLevel {
-		log.Infof("Clamping loglevel to %s", log.ErrorLevel)
-		config.Logging.Level = log.ErrorLevel
+	func() {
+		config := Config()
+		config.Lock()
+		defer config.Unlock()
+		if config.Logging.Level < log.ErrorLevel {
+			log.Infof("Clamping loglevel to %s", log.ErrorLevel)
+			config.Logging.Level = log.ErrorLevel
+		}
+		if config.Logging.Level != log.GetLevel() {
+			log.Infof("Updating loglevel from % s to %s", log.GetLevel(), config.Logging.Level)
+			log.SetLevel(config.Logging.Level)
+		}
+	}()
+	if err != nil {
+		return err
 	}
-	if config.Logging.Level != log.GetLevel() {
-		log.Infof("Updating loglevel from % s to %s", log.GetLevel(), config.Logging.Level)
-		log.SetLevel(config.Logging.Level)
+
+	reloadHooksMutex.Lock()
+	hooks := append([]func(){}, reloadHooks...)
+	reloadHooksMutex.Unlock()
+	for _, hook := range hooks {
+		hook()
 	}
-	return err
+	return nil
+}
+
+// OnReload registers a function that is called after ReloadConfig() has successfully loaded the new configuration.
+func OnReload(hook func()) {
+	reloadHooksMutex.Lock()
+	defer reloadHooksMutex.Unlock()
+	reloadHooks = append(reloadHooks, hook)
 }
 
 func Config() *Configuration {
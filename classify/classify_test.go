@@ -0,0 +1,193 @@
+package classify
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/smuething/devicemonitor/pcl"
+)
+
+func extract(t *testing.T, data string) Features {
+	t.Helper()
+	tokens, err := pcl.Tokenize([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Extract(tokens)
+}
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want Features
+	}{
+		{
+			name: "empty",
+			want: Features{},
+		},
+		{
+			name: "plain text",
+			data: "\x1bEab c\r\n\tx\r\nlast",
+			want: Features{MaxLineWidth: 9, Lines: 3, Text: "ab c\r\n\tx\r\nlast"},
+		},
+		{
+			name: "blank lines do not count",
+			data: "a\r\n\r\n  \r\n\fb\f",
+			want: Features{MaxLineWidth: 1, Lines: 2, Text: "a\r\n\r\n  \r\n\fb\f"},
+		},
+		{
+			name: "absolute positioning",
+			data: "\x1b*p300x400Y",
+			want: Features{AbsolutePositioning: true},
+		},
+		{
+			name: "relative positioning and origin",
+			data: "\x1b*p+30x-40Y\x1b&a0H",
+			want: Features{},
+		},
+		{
+			name: "landscape",
+			data: "\x1b&l1O",
+			want: Features{Landscape: true},
+		},
+		{
+			name: "reverse portrait",
+			data: "\x1b&l2O",
+			want: Features{},
+		},
+		{
+			name: "macros",
+			data: "\x1b&f1y3X",
+			want: Features{Macros: true},
+		},
+		{
+			name: "raster graphics",
+			data: "\x1b*r1A\x1b*b1W\x00\x1b*rB",
+			want: Features{Graphics: true},
+		},
+		{
+			name: "empty raster rows",
+			data: "\x1b*b0W",
+			want: Features{},
+		},
+		{
+			name: "page sizes",
+			data: "\x1b&l26A\x1b&l2a26A",
+			want: Features{PageSizes: []int{26, 2}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extract(t, tt.data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func flag(b bool) *bool {
+	return &b
+}
+
+func TestMatches(t *testing.T) {
+	features := Features{
+		AbsolutePositioning: true,
+		MaxLineWidth:        80,
+		PageSizes:           []int{26},
+		Text:                "RECHNUNG Nr. 42",
+	}
+
+	tests := []struct {
+		name string
+		rule Rule
+		want bool
+	}{
+		{"no conditions", Rule{}, true},
+		{"flag set", Rule{AbsolutePositioning: flag(true)}, true},
+		{"flag not set", Rule{AbsolutePositioning: flag(false)}, false},
+		{"flag cleared", Rule{Landscape: flag(false)}, true},
+		{"all flags", Rule{AbsolutePositioning: flag(true), Landscape: flag(false), Macros: flag(false), Graphics: flag(false)}, true},
+		{"min line width", Rule{MinLineWidth: 80}, true},
+		{"min line width too wide", Rule{MinLineWidth: 81}, false},
+		{"max line width", Rule{MaxLineWidth: 80}, true},
+		{"max line width too narrow", Rule{MaxLineWidth: 79}, false},
+		{"page size", Rule{PageSizes: []int{2, 26}}, true},
+		{"other page size", Rule{PageSizes: []int{2}}, false},
+		{"pattern", Rule{Pattern: `RECHNUNG\s+Nr`}, true},
+		{"pattern does not match", Rule{Pattern: "LIEFERSCHEIN"}, false},
+		{"one condition fails", Rule{Pattern: "RECHNUNG", Graphics: flag(true)}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Type = "form"
+			c, err := New([]Rule{tt.rule}, "plain")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.rules[0].Matches(features); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassify(t *testing.T) {
+	rules := []Rule{
+		{Name: "invoice", Type: "invoice", Pattern: "RECHNUNG"},
+		{Type: "form", AbsolutePositioning: flag(true)},
+		{Name: "wide", Type: "list", MinLineWidth: 100},
+	}
+	c, err := New(rules, "plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		features Features
+		typ      string
+		rule     string
+	}{
+		{"first rule wins", Features{AbsolutePositioning: true, Text: "RECHNUNG"}, "invoice", "invoice"},
+		{"unnamed rule", Features{AbsolutePositioning: true}, "form", "rule 2"},
+		{"last rule", Features{MaxLineWidth: 132}, "list", "wide"},
+		{"default", Features{MaxLineWidth: 80}, "plain", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			typ, rule := c.Classify(tt.features)
+			if typ != tt.typ || rule != tt.rule {
+				t.Errorf("got %s by %q, want %s by %q", typ, rule, tt.typ, tt.rule)
+			}
+		})
+	}
+
+	if got, want := c.Types(), []string{"plain", "invoice", "form", "list"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Types() = %v, want %v", got, want)
+	}
+	if rules[1].Name != "" {
+		t.Errorf("New modified the rules it was given")
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []Rule
+	}{
+		{"missing type", []Rule{{Name: "broken"}}},
+		{"invalid pattern", []Rule{{Type: "form", Pattern: "("}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.rules, "plain"); err == nil {
+				t.Errorf("got no error")
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+// Package classify decides what kind of document a print job contains, based on configurable rules
+// about the commands and the text of the job.
+package classify
+
+import (
+	"strings"
+
+	"github.com/smuething/devicemonitor/pcl"
+)
+
+// Features summarizes the properties of a PCL job that rules can check.
+type Features struct {
+	AbsolutePositioning bool // the cursor is moved to absolute positions on the page
+	Landscape           bool
+	Macros              bool // the job defines or calls macros, typically for form overlays
+	Graphics            bool // raster graphics, filled areas or HP-GL/2
+	MaxLineWidth        int  // number of characters of the longest line of text
+	Lines               int
+	PageSizes           []int // the page sizes selected with ESC&l#A
+	Text                string
+}
+
+// Extract collects the features of the tokens of a job.
+func Extract(tokens []pcl.Token) Features {
+	var f Features
+	var text strings.Builder
+	column := 0
+	blank := true // nothing has been printed on the current line
+	for _, t := range tokens {
+		switch t.Type {
+		case pcl.Text:
+			text.Write(t.Raw)
+			for _, b := range t.Raw {
+				switch {
+				case b == '\r':
+					column = 0
+				case b == '\n' || b == '\f':
+					if !blank {
+						f.Lines++
+					}
+					column = 0
+					blank = true
+				case b == '\t':
+					column += 8 - column%8
+				case b == ' ':
+					column++
+				case b > ' ':
+					column++
+					blank = false
+					if column > f.MaxLineWidth {
+						f.MaxLineWidth = column
+					}
+				}
+			}
+		case pcl.Sequence:
+			for _, c := range t.Commands {
+				f.command(c)
+			}
+		}
+	}
+	if !blank {
+		f.Lines++
+	}
+	f.Text = text.String()
+	return f
+}
+
+func (f *Features) command(c pcl.Command) {
+	switch c.Name() {
+	case "*pX", "*pY", "&aH", "&aV":
+		f.AbsolutePositioning = f.AbsolutePositioning || isAbsolute(c)
+	case "&lO":
+		// 1 is landscape, 3 reverse landscape
+		f.Landscape = f.Landscape || c.Int()%2 == 1
+	case "&fY", "&fX":
+		f.Macros = true
+	case "*rA", "*cP", "%B":
+		f.Graphics = true
+	case "*bW", "*bV":
+		f.Graphics = f.Graphics || len(c.Data) > 0
+	case "&lA":
+		for _, size := range f.PageSizes {
+			if size == c.Int() {
+				return
+			}
+		}
+		f.PageSizes = append(f.PageSizes, c.Int())
+	}
+}
+
+// isAbsolute reports whether a cursor positioning command moves to an absolute position other than the origin.
+func isAbsolute(c pcl.Command) bool {
+	return !strings.HasPrefix(c.Value, "+") && !strings.HasPrefix(c.Value, "-") && c.Float() > 0
+}
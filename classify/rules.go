@@ -0,0 +1,112 @@
+package classify
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Rule assigns a type to the jobs that have all of the features the rule asks for. Conditions
+// that are not set are ignored.
+type Rule struct {
+	Name                string
+	Type                string // the type of the jobs that match
+	AbsolutePositioning *bool
+	Landscape           *bool
+	Macros              *bool
+	Graphics            *bool
+	MinLineWidth        int
+	MaxLineWidth        int
+	PageSizes           []int  // at least one of these page sizes is selected
+	Pattern             string // regular expression that must match the text of the job
+	pattern             *regexp.Regexp
+}
+
+func matchesFlag(condition *bool, value bool) bool {
+	return condition == nil || *condition == value
+}
+
+// Matches reports whether the features satisfy all conditions of the rule.
+func (r *Rule) Matches(f Features) bool {
+	if !matchesFlag(r.AbsolutePositioning, f.AbsolutePositioning) ||
+		!matchesFlag(r.Landscape, f.Landscape) ||
+		!matchesFlag(r.Macros, f.Macros) ||
+		!matchesFlag(r.Graphics, f.Graphics) {
+		return false
+	}
+	if r.MinLineWidth > 0 && f.MaxLineWidth < r.MinLineWidth {
+		return false
+	}
+	if r.MaxLineWidth > 0 && f.MaxLineWidth > r.MaxLineWidth {
+		return false
+	}
+	if len(r.PageSizes) > 0 && !containsAny(r.PageSizes, f.PageSizes) {
+		return false
+	}
+	if r.pattern != nil && !r.pattern.MatchString(f.Text) {
+		return false
+	}
+	return true
+}
+
+func containsAny(stack []int, needles []int) bool {
+	for _, hay := range stack {
+		for _, needle := range needles {
+			if hay == needle {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Classifier applies rules in order, the first matching rule determines the type of a job.
+type Classifier struct {
+	rules       []Rule
+	defaultType string
+}
+
+// New creates a classifier that falls back to defaultType if no rule matches.
+func New(rules []Rule, defaultType string) (*Classifier, error) {
+	c := &Classifier{
+		rules:       make([]Rule, len(rules)),
+		defaultType: defaultType,
+	}
+	copy(c.rules, rules)
+	for i := range c.rules {
+		r := &c.rules[i]
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if r.Type == "" {
+			return nil, fmt.Errorf("Classification rule %s has no type", r.Name)
+		}
+		if r.Pattern != "" {
+			pattern, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid pattern in classification rule %s: %s", r.Name, err)
+			}
+			r.pattern = pattern
+		}
+	}
+	return c, nil
+}
+
+// Classify returns the type of a job with the given features and the name of the rule that
+// decided it, which is empty if the default type was used.
+func (c *Classifier) Classify(f Features) (string, string) {
+	for i := range c.rules {
+		if c.rules[i].Matches(f) {
+			return c.rules[i].Type, c.rules[i].Name
+		}
+	}
+	return c.defaultType, ""
+}
+
+// Types returns the types the classifier can produce.
+func (c *Classifier) Types() []string {
+	types := []string{c.defaultType}
+	for _, r := range c.rules {
+		types = append(types, r.Type)
+	}
+	return types
+}
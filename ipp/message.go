@@ -0,0 +1,225 @@
+// Package ipp implements a minimal IPP/1.1 server (RFC 8010, RFC 8011) that exposes the queues
+// of a monitor as printers.
+package ipp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// delimiter tags
+const (
+	tagOperation   = 0x01
+	tagJob         = 0x02
+	tagEnd         = 0x03
+	tagPrinter     = 0x04
+	tagUnsupported = 0x05
+)
+
+// value tags
+const (
+	tagInteger         = 0x21
+	tagBoolean         = 0x22
+	tagEnum            = 0x23
+	tagText            = 0x41
+	tagName            = 0x42
+	tagKeyword         = 0x44
+	tagURI             = 0x45
+	tagURIScheme       = 0x46
+	tagCharset         = 0x47
+	tagNaturalLanguage = 0x48
+	tagMimeMediaType   = 0x49
+)
+
+// operations
+const (
+	opPrintJob             = 0x0002
+	opValidateJob          = 0x0004
+	opGetJobs              = 0x000a
+	opGetPrinterAttributes = 0x000b
+)
+
+// status codes
+const (
+	statusOK                        = 0x0000
+	statusBadRequest                = 0x0400
+	statusNotFound                  = 0x0406
+	statusDocumentFormatUnsupported = 0x040a
+	statusInternalError             = 0x0500
+	statusOperationNotSupported     = 0x0501
+	statusVersionNotSupported       = 0x0503
+)
+
+type attribute struct {
+	tag    byte
+	name   string
+	values [][]byte
+}
+
+func (a *attribute) String() string {
+	if len(a.values) == 0 {
+		return ""
+	}
+	return string(a.values[0])
+}
+
+type group struct {
+	tag        byte
+	attributes []*attribute
+}
+
+func (g *group) add(tag byte, name string, values ...[]byte) {
+	g.attributes = append(g.attributes, &attribute{tag: tag, name: name, values: values})
+}
+
+func (g *group) addStrings(tag byte, name string, values ...string) {
+	raw := make([][]byte, len(values))
+	for i, v := range values {
+		raw[i] = []byte(v)
+	}
+	g.add(tag, name, raw...)
+}
+
+func (g *group) addInts(tag byte, name string, values ...int) {
+	raw := make([][]byte, len(values))
+	for i, v := range values {
+		raw[i] = make([]byte, 4)
+		binary.BigEndian.PutUint32(raw[i], uint32(int32(v)))
+	}
+	g.add(tag, name, raw...)
+}
+
+func (g *group) addBool(name string, value bool) {
+	b := byte(0)
+	if value {
+		b = 1
+	}
+	g.add(tagBoolean, name, []byte{b})
+}
+
+// message is an IPP request or response. Code is the operation of a request and the status of a response.
+type message struct {
+	major, minor byte
+	code         uint16
+	requestID    uint32
+	groups       []*group
+}
+
+func (m *message) group(tag byte) *group {
+	g := &group{tag: tag}
+	m.groups = append(m.groups, g)
+	return g
+}
+
+// attribute returns the first attribute with the given name in a group with the given tag or nil.
+func (m *message) attribute(tag byte, name string) *attribute {
+	for _, g := range m.groups {
+		if g.tag != tag {
+			continue
+		}
+		for _, a := range g.attributes {
+			if a.name == name {
+				return a
+			}
+		}
+	}
+	return nil
+}
+
+func (m *message) operationAttribute(name string) string {
+	if a := m.attribute(tagOperation, name); a != nil {
+		return a.String()
+	}
+	return ""
+}
+
+// decode reads the header and the attributes of a message, r is left at the start of the document data.
+func decode(r io.Reader) (*message, error) {
+	var header struct {
+		Major, Minor byte
+		Code         uint16
+		RequestID    uint32
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, err
+	}
+	m := &message{major: header.Major, minor: header.Minor, code: header.Code, requestID: header.RequestID}
+
+	var g *group
+	var last *attribute
+	for {
+		var tag [1]byte
+		if _, err := io.ReadFull(r, tag[:]); err != nil {
+			return nil, err
+		}
+		if tag[0] == tagEnd {
+			return m, nil
+		}
+		if tag[0] < 0x10 {
+			g = m.group(tag[0])
+			last = nil
+			continue
+		}
+		if g == nil {
+			return nil, fmt.Errorf("Attribute outside of attribute group")
+		}
+		name, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		if name == "" {
+			// additional value of the previous attribute
+			if last == nil {
+				return nil, fmt.Errorf("Additional value without attribute")
+			}
+			last.values = append(last.values, []byte(value))
+			continue
+		}
+		last = &attribute{tag: tag[0], name: name, values: [][]byte{[]byte(value)}}
+		g.attributes = append(g.attributes, last)
+	}
+}
+
+func readString(r io.Reader) (string, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return "", err
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func (m *message) encode(w io.Writer) error {
+	buf := &bytes.Buffer{}
+	buf.Write([]byte{m.major, m.minor})
+	binary.Write(buf, binary.BigEndian, m.code)
+	binary.Write(buf, binary.BigEndian, m.requestID)
+	for _, g := range m.groups {
+		buf.WriteByte(g.tag)
+		for _, a := range g.attributes {
+			for i, value := range a.values {
+				buf.WriteByte(a.tag)
+				name := a.name
+				if i > 0 {
+					name = ""
+				}
+				binary.Write(buf, binary.BigEndian, uint16(len(name)))
+				buf.WriteString(name)
+				binary.Write(buf, binary.BigEndian, uint16(len(value)))
+				buf.Write(value)
+			}
+		}
+	}
+	buf.WriteByte(tagEnd)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,341 @@
+package ipp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/smuething/devicemonitor/monitor"
+)
+
+const (
+	DefaultAddress = ":631"
+	printersPath   = "/printers/"
+	// number of finished jobs that keep their job id and are listed as completed
+	completedJobs = 100
+)
+
+// job states
+const (
+	jobPending     = 3
+	jobPendingHeld = 4
+	jobProcessing  = 5
+	jobCanceled    = 7
+	jobAborted     = 8
+	jobCompleted   = 9
+)
+
+// printer states
+const (
+	printerIdle       = 3
+	printerProcessing = 4
+)
+
+var documentFormats = []string{
+	"application/octet-stream",
+	"application/vnd.hp-pcl",
+	"application/postscript",
+}
+
+// Server exposes every queue of a monitor as an IPP printer at /printers/<device>.
+type Server struct {
+	m       sync.Mutex
+	monitor *monitor.Monitor
+	started time.Time
+	ids     map[string]int // IPP job ids of the queued and the recently finished jobs
+	nextID  int
+}
+
+func NewServer(m *monitor.Monitor) *Server {
+	return &Server{
+		monitor: m,
+		started: time.Now(),
+		ids:     make(map[string]int),
+		nextID:  1,
+	}
+}
+
+func (s *Server) jobID(name string) int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	id, found := s.ids[name]
+	if !found {
+		id = s.nextID
+		s.nextID++
+		s.ids[name] = id
+	}
+	return id
+}
+
+// ListenAndServe accepts IPP requests on address until ctx is done.
+func (s *Server) ListenAndServe(ctx context.Context, address string) error {
+	if address == "" {
+		address = DefaultAddress
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	log.Infof("Accepting IPP print jobs on %s", listener.Addr())
+
+	server := &http.Server{Handler: s}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	err = server.Serve(listener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// request bundles an IPP request with the HTTP request it arrived in.
+type request struct {
+	*message
+	http  *http.Request
+	body  *bufio.Reader
+	queue *monitor.Queue
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/ipp" {
+		http.Error(w, "IPP requests only", http.StatusBadRequest)
+		return
+	}
+
+	body := bufio.NewReader(r.Body)
+	req, err := decode(body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid IPP request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	resp := &message{major: 1, minor: 1, requestID: req.requestID}
+	op := resp.group(tagOperation)
+	op.addStrings(tagCharset, "attributes-charset", "utf-8")
+	op.addStrings(tagNaturalLanguage, "attributes-natural-language", "en")
+
+	resp.code = s.handle(&request{message: req, http: r, body: body}, resp)
+	if resp.code >= statusBadRequest {
+		log.Warnf("IPP operation 0x%04x from %s failed with status 0x%04x", req.code, r.RemoteAddr, resp.code)
+	}
+
+	w.Header().Set("Content-Type", "application/ipp")
+	if err = resp.encode(w); err != nil {
+		log.Errorf("Could not send IPP response: %s", err)
+	}
+}
+
+func (s *Server) handle(req *request, resp *message) uint16 {
+	// IPP/2.x clients fall back to the 1.1 operations we answer with, IPP/2.x does not change them
+	if req.major < 1 || req.major > 2 {
+		return statusVersionNotSupported
+	}
+
+	// the printer is identified by the printer-uri attribute, but some clients only get the path right
+	path := req.http.URL.Path
+	if uri := req.operationAttribute("printer-uri"); uri != "" {
+		if i := strings.Index(uri, printersPath); i >= 0 {
+			path = uri[i:]
+		}
+	}
+	device := strings.Trim(strings.TrimPrefix(path, printersPath), "/")
+	req.queue = s.monitor.Queue(device)
+	if req.queue == nil {
+		return statusNotFound
+	}
+
+	switch req.code {
+	case opPrintJob:
+		return s.printJob(req, resp)
+	case opValidateJob:
+		return s.validateJob(req, resp)
+	case opGetJobs:
+		return s.getJobs(req, resp)
+	case opGetPrinterAttributes:
+		return s.getPrinterAttributes(req, resp)
+	default:
+		return statusOperationNotSupported
+	}
+}
+
+func (s *Server) printerURI(req *request) string {
+	return fmt.Sprintf("ipp://%s%s%s", req.http.Host, printersPath, strings.ToLower(req.queue.Device))
+}
+
+func (s *Server) validateJob(req *request, resp *message) uint16 {
+	format := req.operationAttribute("document-format")
+	if format == "" {
+		return statusOK
+	}
+	for _, f := range documentFormats {
+		if f == format {
+			return statusOK
+		}
+	}
+	resp.group(tagUnsupported).addStrings(tagMimeMediaType, "document-format", format)
+	return statusDocumentFormatUnsupported
+}
+
+func (s *Server) printJob(req *request, resp *message) uint16 {
+	if status := s.validateJob(req, resp); status != statusOK {
+		return status
+	}
+
+	host, _, _ := net.SplitHostPort(req.http.RemoteAddr)
+	job, err := req.queue.Receive(req.body, monitor.JobInfo{
+		User:  req.operationAttribute("requesting-user-name"),
+		Host:  host,
+		Title: req.operationAttribute("job-name"),
+	})
+	if err != nil {
+		log.Errorf("Could not receive IPP job for %s: %s", req.queue.Device, err)
+		return statusInternalError
+	}
+	log.Infof("Received IPP job %s from %s@%s for queue %s", job.Name, job.User, job.Host, req.queue.Name())
+
+	s.pruneJobIDs(s.finishedJobs())
+	s.addJobAttributes(req, resp.group(tagJob), s.jobID(job.Name), jobPending)
+	return statusOK
+}
+
+func (s *Server) addJobAttributes(req *request, g *group, id int, state int) {
+	g.addInts(tagInteger, "job-id", id)
+	g.addStrings(tagURI, "job-uri", fmt.Sprintf("%s/%d", s.printerURI(req), id))
+	g.addInts(tagEnum, "job-state", state)
+	g.addStrings(tagKeyword, "job-state-reasons", "none")
+}
+
+// finishedJobs returns the records of the most recently finished jobs of all queues, newest first.
+func (s *Server) finishedJobs() []monitor.JobRecord {
+	records := s.monitor.JobRecords()
+	finished := make([]monitor.JobRecord, 0, completedJobs)
+	for i := len(records) - 1; i >= 0 && len(finished) < completedJobs; i-- {
+		if records[i].Status.Finished() {
+			finished = append(finished, records[i])
+		}
+	}
+	return finished
+}
+
+// pruneJobIDs forgets the ids of jobs that are neither queued nor among the recently finished jobs.
+func (s *Server) pruneJobIDs(finished []monitor.JobRecord) {
+	known := make(map[string]bool)
+	for _, j := range s.monitor.QueuedJobs() {
+		known[j.Name] = true
+	}
+	for _, r := range finished {
+		known[r.ID] = true
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	for name := range s.ids {
+		if !known[name] {
+			delete(s.ids, name)
+		}
+	}
+}
+
+func (s *Server) getJobs(req *request, resp *message) uint16 {
+	finished := s.finishedJobs()
+	s.pruneJobIDs(finished)
+
+	if which := req.operationAttribute("which-jobs"); which == "completed" {
+		for _, r := range finished {
+			if !strings.EqualFold(r.Device, req.queue.Device) {
+				continue
+			}
+			state := jobCompleted
+			switch r.Status {
+			case monitor.JobFailed:
+				state = jobAborted
+			case monitor.JobCancelled:
+				state = jobCanceled
+			}
+			g := resp.group(tagJob)
+			s.addJobAttributes(req, g, s.jobID(r.ID), state)
+			g.addStrings(tagName, "job-name", jobName(r.Title, r.ID))
+		}
+		return statusOK
+	}
+
+	jobs := s.queuedJobs(req.queue)
+	for _, j := range jobs {
+		state := jobPending
+		if j.Held {
+			state = jobPendingHeld
+		}
+		if j.Active {
+			state = jobProcessing
+		}
+		g := resp.group(tagJob)
+		s.addJobAttributes(req, g, s.jobID(j.Name), state)
+		g.addStrings(tagName, "job-name", jobName(j.Title, j.Name))
+	}
+	return statusOK
+}
+
+// jobName returns the name the client submitted the job with, jobs without one are listed by their id.
+func jobName(title string, id string) string {
+	if title != "" {
+		return title
+	}
+	return id
+}
+
+func (s *Server) queuedJobs(queue *monitor.Queue) []monitor.QueuedJob {
+	jobs := make([]monitor.QueuedJob, 0)
+	for _, j := range s.monitor.QueuedJobs() {
+		if strings.EqualFold(j.Device, queue.Device) {
+			jobs = append(jobs, j)
+		}
+	}
+	sort.Slice(jobs, func(i, k int) bool {
+		return jobs[i].Time.Before(jobs[k].Time)
+	})
+	return jobs
+}
+
+func (s *Server) getPrinterAttributes(req *request, resp *message) uint16 {
+	jobs := s.queuedJobs(req.queue)
+	state := printerIdle
+	if len(jobs) > 0 || req.queue.IsSpooling() {
+		state = printerProcessing
+	}
+
+	g := resp.group(tagPrinter)
+	g.addStrings(tagURI, "printer-uri-supported", s.printerURI(req))
+	g.addStrings(tagKeyword, "uri-security-supported", "none")
+	g.addStrings(tagKeyword, "uri-authentication-supported", "none")
+	g.addStrings(tagName, "printer-name", req.queue.Device)
+	g.addStrings(tagText, "printer-info", req.queue.Name())
+	g.addStrings(tagText, "printer-make-and-model", "devicemonitor")
+	g.addInts(tagEnum, "printer-state", state)
+	g.addStrings(tagKeyword, "printer-state-reasons", "none")
+	g.addBool("printer-is-accepting-jobs", true)
+	g.addInts(tagInteger, "queued-job-count", len(jobs))
+	g.addInts(tagInteger, "printer-up-time", int(time.Since(s.started).Seconds())+1)
+	g.addStrings(tagKeyword, "ipp-versions-supported", "1.0", "1.1")
+	g.addInts(tagEnum, "operations-supported", opPrintJob, opValidateJob, opGetJobs, opGetPrinterAttributes)
+	g.addStrings(tagCharset, "charset-configured", "utf-8")
+	g.addStrings(tagCharset, "charset-supported", "utf-8")
+	g.addStrings(tagNaturalLanguage, "natural-language-configured", "en")
+	g.addStrings(tagNaturalLanguage, "generated-natural-language-supported", "en")
+	g.addStrings(tagMimeMediaType, "document-format-default", documentFormats[0])
+	g.addStrings(tagMimeMediaType, "document-format-supported", documentFormats...)
+	g.addStrings(tagKeyword, "pdl-override-supported", "not-attempted")
+	g.addStrings(tagKeyword, "compression-supported", "none")
+	return statusOK
+}
@@ -0,0 +1,183 @@
+package ipp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smuething/devicemonitor/monitor"
+)
+
+func startServer(t *testing.T) (*monitor.Monitor, string) {
+	t.Helper()
+	m := monitor.NewMonitor(t.TempDir(), nil)
+	if _, err := m.AddTCPPort("NET", "net.txt", "127.0.0.1:0", "net"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Start(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Monitor failed: %s", err)
+		}
+	})
+	for deadline := time.Now().Add(5 * time.Second); m.Status().State != monitor.StateRunning; {
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout waiting for the monitor to start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	server := httptest.NewServer(NewServer(m))
+	t.Cleanup(server.Close)
+	return m, server.URL + printersPath + "net"
+}
+
+// send posts an IPP request with the given operation attributes and document data.
+func send(t *testing.T, url string, major byte, op uint16, attributes map[string]string, data string) *message {
+	t.Helper()
+	req := &message{major: major, minor: 0, code: op, requestID: 42}
+	g := req.group(tagOperation)
+	g.addStrings(tagCharset, "attributes-charset", "utf-8")
+	g.addStrings(tagNaturalLanguage, "attributes-natural-language", "en")
+	for name, value := range attributes {
+		g.addStrings(tagName, name, value)
+	}
+	body := &bytes.Buffer{}
+	if err := req.encode(body); err != nil {
+		t.Fatal(err)
+	}
+	body.WriteString(data)
+
+	r, err := http.Post(url, "application/ipp", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+	resp, err := decode(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.requestID != req.requestID {
+		t.Errorf("got request id %d, want %d", resp.requestID, req.requestID)
+	}
+	return resp
+}
+
+func nextJob(t *testing.T, m *monitor.Monitor) *monitor.Job {
+	t.Helper()
+	select {
+	case j := <-m.Jobs():
+		return j
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for a job")
+		return nil
+	}
+}
+
+// jobNames returns the job-name attributes of all job groups of a response.
+func jobNames(resp *message) string {
+	names := make([]string, 0)
+	for _, g := range resp.groups {
+		for _, a := range g.attributes {
+			if g.tag == tagJob && a.name == "job-name" {
+				names = append(names, a.String())
+			}
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func TestPrintJob(t *testing.T) {
+	m, url := startServer(t)
+
+	resp := send(t, url, 1, opPrintJob, map[string]string{
+		"requesting-user-name": "alice",
+		"job-name":             "Rechnung 17",
+	}, "\x1bEdata\x1bE")
+	if resp.code != statusOK {
+		t.Fatalf("got status 0x%04x", resp.code)
+	}
+	if a := resp.attribute(tagJob, "job-id"); a == nil || binary.BigEndian.Uint32(a.values[0]) != 1 {
+		t.Errorf("got job-id %v, want 1", a)
+	}
+
+	j := nextJob(t, m)
+	data, err := ioutil.ReadFile(j.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "\x1bEdata\x1bE" || j.User != "alice" || j.Title != "Rechnung 17" {
+		t.Errorf("got job %q from %s titled %q", data, j.User, j.Title)
+	}
+	j.Done()
+
+	resp = send(t, url, 1, opPrintJob, map[string]string{"document-format": "application/pdf"}, "%PDF-1.4")
+	if resp.code != statusDocumentFormatUnsupported {
+		t.Errorf("got status 0x%04x for an unsupported format", resp.code)
+	}
+}
+
+func TestGetJobs(t *testing.T) {
+	m, url := startServer(t)
+
+	send(t, url, 1, opPrintJob, map[string]string{"job-name": "Rechnung 17"}, "first")
+	first := nextJob(t, m)
+	send(t, url, 1, opPrintJob, nil, "second")
+
+	resp := send(t, url, 1, opGetJobs, nil, "")
+	if resp.code != statusOK {
+		t.Fatalf("got status 0x%04x", resp.code)
+	}
+	second := m.QueuedJobs()[1].Name
+	if got, want := jobNames(resp), "Rechnung 17, "+second; got != want {
+		t.Errorf("got jobs %q, want %q", got, want)
+	}
+
+	first.Done()
+	nextJob(t, m).Done()
+	for deadline := time.Now().Add(5 * time.Second); len(m.QueuedJobs()) > 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout waiting for the jobs to finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	resp = send(t, url, 1, opGetJobs, map[string]string{"which-jobs": "completed"}, "")
+	if got, want := jobNames(resp), second+", Rechnung 17"; got != want {
+		t.Errorf("got completed jobs %q, want %q", got, want)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	_, url := startServer(t)
+
+	tests := []struct {
+		major byte
+		want  uint16
+	}{
+		{1, statusOK},
+		{2, statusOK},
+		{0, statusVersionNotSupported},
+		{3, statusVersionNotSupported},
+	}
+	for _, tt := range tests {
+		resp := send(t, url, tt.major, opGetPrinterAttributes, nil, "")
+		if resp.code != tt.want {
+			t.Errorf("IPP/%d.0: got status 0x%04x, want 0x%04x", tt.major, resp.code, tt.want)
+		}
+		if resp.major != 1 || resp.minor != 1 {
+			t.Errorf("IPP/%d.0: answered with IPP/%d.%d", tt.major, resp.major, resp.minor)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package lpd
+
+import (
+	"strings"
+)
+
+// controlFile holds the parts of an LPD control file that we care about.
+type controlFile struct {
+	host      string
+	user      string
+	jobName   string
+	source    string
+	banner    string
+	dataFiles []string // in the order they are printed, without duplicates for copies
+}
+
+// title returns the most descriptive name of the job.
+func (cf *controlFile) title() string {
+	for _, title := range []string{cf.jobName, cf.source, cf.banner} {
+		if title != "" {
+			return title
+		}
+	}
+	return ""
+}
+
+func parseControlFile(data string) *controlFile {
+	cf := &controlFile{}
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		value := line[1:]
+		switch line[0] {
+		case 'H':
+			cf.host = value
+		case 'P':
+			cf.user = value
+		case 'J':
+			cf.jobName = value
+		case 'N':
+			cf.source = value
+		case 'L':
+			cf.banner = value
+		case 'c', 'd', 'f', 'g', 'l', 'n', 'o', 'p', 'r', 't', 'v':
+			// print commands for the different file formats, we pass the data on as is
+			if !seen[value] {
+				seen[value] = true
+				cf.dataFiles = append(cf.dataFiles, value)
+			}
+		}
+	}
+	return cf
+}
@@ -0,0 +1,52 @@
+package lpd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseControlFile(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		want  controlFile
+		title string
+	}{
+		{
+			name:  "complete",
+			data:  "Hhost\nPalice\nJRechnung 17\nNfile.prn\nLalice\nldfA001host\nUdfA001host\n",
+			want:  controlFile{host: "host", user: "alice", jobName: "Rechnung 17", source: "file.prn", banner: "alice", dataFiles: []string{"dfA001host"}},
+			title: "Rechnung 17",
+		},
+		{
+			name:  "copies",
+			data:  "Hhost\nPbob\nldfA002host\nldfA002host\nldfB002host\n",
+			want:  controlFile{host: "host", user: "bob", dataFiles: []string{"dfA002host", "dfB002host"}},
+			title: "",
+		},
+		{
+			name:  "crlf and formats",
+			data:  "Hhost\r\nNsource.txt\r\nfdfA003host\r\nodfB003host\r\n\r\n",
+			want:  controlFile{host: "host", source: "source.txt", dataFiles: []string{"dfA003host", "dfB003host"}},
+			title: "source.txt",
+		},
+		{
+			name:  "banner only",
+			data:  "Lbanner\nMalice\nS1 2\npdfA004host",
+			want:  controlFile{banner: "banner", dataFiles: []string{"dfA004host"}},
+			title: "banner",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseControlFile(tt.data)
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("got %+v, want %+v", *got, tt.want)
+			}
+			if title := got.title(); title != tt.title {
+				t.Errorf("got title %q, want %q", title, tt.title)
+			}
+		})
+	}
+}
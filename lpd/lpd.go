@@ -0,0 +1,410 @@
+// Package lpd implements the receiving side of the line printer daemon protocol (RFC 1179) and
+// hands the received jobs to the queues of a monitor.
+package lpd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/smuething/devicemonitor/monitor"
+)
+
+const (
+	DefaultAddress = ":515"
+	// connections that do not send anything for this long are dropped
+	idleTimeout        = 2 * time.Minute
+	maxControlFileSize = 64 * 1024
+)
+
+// daemon commands
+const (
+	cmdPrintWaiting = 1
+	cmdReceiveJob   = 2
+	cmdStateShort   = 3
+	cmdStateLong    = 4
+	cmdRemoveJobs   = 5
+)
+
+// subcommands of cmdReceiveJob
+const (
+	subAbortJob    = 1
+	subControlFile = 2
+	subDataFile    = 3
+)
+
+const (
+	ack  = 0
+	nack = 1
+)
+
+// Server accepts print jobs via LPD and submits them to the queues of a monitor. LPD queue names
+// are mapped to devices, names without a mapping are looked up as device names.
+type Server struct {
+	m       sync.Mutex
+	monitor *monitor.Monitor
+	queues  map[string]string
+}
+
+func NewServer(m *monitor.Monitor, queues map[string]string) *Server {
+	s := &Server{
+		monitor: m,
+		queues:  make(map[string]string),
+	}
+	s.SetQueues(queues)
+	return s
+}
+
+// SetQueues replaces the mapping from LPD queue names to devices.
+func (s *Server) SetQueues(queues map[string]string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.queues = make(map[string]string, len(queues))
+	for name, device := range queues {
+		s.queues[strings.ToLower(name)] = device
+	}
+}
+
+func (s *Server) queue(name string) *monitor.Queue {
+	s.m.Lock()
+	device, found := s.queues[strings.ToLower(name)]
+	s.m.Unlock()
+	if !found {
+		device = name
+	}
+	return s.monitor.Queue(device)
+}
+
+// ListenAndServe accepts connections on address until ctx is done.
+func (s *Server) ListenAndServe(ctx context.Context, address string) error {
+	if address == "" {
+		address = DefaultAddress
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	log.Infof("Accepting LPD print jobs on %s", listener.Addr())
+
+	var m sync.Mutex
+	conns := make(map[net.Conn]struct{})
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+		m.Lock()
+		defer m.Unlock()
+		for conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		m.Lock()
+		conns[conn] = struct{}{}
+		m.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				m.Lock()
+				delete(conns, conn)
+				m.Unlock()
+				conn.Close()
+			}()
+			if err := s.serve(conn); err != nil {
+				log.Errorf("LPD connection from %s failed: %s", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *conn) readLine() (string, error) {
+	c.SetReadDeadline(time.Now().Add(idleTimeout))
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\n"), nil
+}
+
+// idleReader reads from the connection and drops it if the client stops sending for idleTimeout,
+// while transfers of any length can take as long as they keep going.
+type idleReader struct {
+	c *conn
+}
+
+func (r idleReader) Read(p []byte) (int, error) {
+	r.c.SetReadDeadline(time.Now().Add(idleTimeout))
+	return r.c.r.Read(p)
+}
+
+func (c *conn) reply(code byte) error {
+	_, err := c.Write([]byte{code})
+	return err
+}
+
+func (s *Server) serve(nc net.Conn) error {
+	c := &conn{Conn: nc, r: bufio.NewReader(nc)}
+
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	if line == "" {
+		return fmt.Errorf("Empty command")
+	}
+	cmd, args := line[0], strings.Fields(line[1:])
+	if len(args) == 0 {
+		return fmt.Errorf("Missing queue name in command %d", cmd)
+	}
+
+	switch cmd {
+	case cmdPrintWaiting:
+		// jobs are printed as soon as they arrive
+		return nil
+	case cmdReceiveJob:
+		queue := s.queue(args[0])
+		if queue == nil {
+			c.reply(nack)
+			return fmt.Errorf("Unknown queue %s", args[0])
+		}
+		if err = c.reply(ack); err != nil {
+			return err
+		}
+		return s.receiveJob(c, queue)
+	case cmdStateShort, cmdStateLong:
+		status := "no entries\n"
+		if s.queue(args[0]) == nil {
+			status = fmt.Sprintf("unknown queue %s\n", args[0])
+		}
+		_, err = c.Write([]byte(status))
+		return err
+	case cmdRemoveJobs:
+		// jobs are gone as soon as they have been received
+		return nil
+	default:
+		return fmt.Errorf("Unknown command %d", cmd)
+	}
+}
+
+// job collects the control file and the data files of a job, which can arrive in any order.
+type job struct {
+	control   *controlFile
+	dataFiles map[string]string // name in the control file -> temporary file
+}
+
+func (j *job) cleanup() {
+	for _, file := range j.dataFiles {
+		os.Remove(file)
+	}
+	j.dataFiles = make(map[string]string)
+	j.control = nil
+}
+
+// complete reports whether the control file and all data files it references have arrived.
+func (j *job) complete() bool {
+	if j.control == nil {
+		return false
+	}
+	for _, name := range j.control.dataFiles {
+		if _, found := j.dataFiles[name]; !found {
+			return false
+		}
+	}
+	return true
+}
+
+// done removes the files of a submitted job, so that the connection can carry the next one.
+func (j *job) done() {
+	for _, name := range j.control.dataFiles {
+		os.Remove(j.dataFiles[name])
+		delete(j.dataFiles, name)
+	}
+	j.control = nil
+}
+
+func (s *Server) receiveJob(c *conn, queue *monitor.Queue) error {
+	j := &job{dataFiles: make(map[string]string)}
+	defer j.cleanup()
+
+	for {
+		line, err := c.readLine()
+		if err == io.EOF {
+			// some clients just close the connection after sending the last file
+			return s.submit(c, queue, j)
+		}
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			return s.submit(c, queue, j)
+		}
+
+		switch line[0] {
+		case subAbortJob:
+			j.cleanup()
+			if err = c.reply(ack); err != nil {
+				return err
+			}
+		case subControlFile, subDataFile:
+			fields := strings.Fields(line[1:])
+			if len(fields) != 2 {
+				c.reply(nack)
+				return fmt.Errorf("Invalid subcommand: %q", line)
+			}
+			size, err := strconv.ParseInt(fields[0], 10, 64)
+			if err != nil || size < 0 {
+				c.reply(nack)
+				return fmt.Errorf("Invalid file size: %q", fields[0])
+			}
+			if err = c.reply(ack); err != nil {
+				return err
+			}
+			if line[0] == subControlFile {
+				err = s.receiveControlFile(c, j, size)
+			} else {
+				err = s.receiveDataFile(c, j, fields[1], size)
+			}
+			if err != nil {
+				c.reply(nack)
+				return err
+			}
+			if line[0] == subDataFile && size == 0 {
+				// the client has closed the connection
+				return s.submit(c, queue, j)
+			}
+			if j.complete() {
+				// submit right away, a connection can carry several jobs and the client
+				// has to learn about jobs that could not be queued
+				err = s.submit(c, queue, j)
+				j.done()
+				if err != nil {
+					c.reply(nack)
+					return err
+				}
+			}
+			if err = c.reply(ack); err != nil {
+				return err
+			}
+		default:
+			c.reply(nack)
+			return fmt.Errorf("Unknown subcommand %d", line[0])
+		}
+	}
+}
+
+// readTrailer consumes the zero byte that terminates every file.
+func (c *conn) readTrailer() error {
+	b, err := c.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if b != 0 {
+		return fmt.Errorf("Missing end of file marker")
+	}
+	return nil
+}
+
+func (s *Server) receiveControlFile(c *conn, j *job, size int64) error {
+	if size > maxControlFileSize {
+		return fmt.Errorf("Control file too large: %d bytes", size)
+	}
+	c.SetReadDeadline(time.Now().Add(idleTimeout))
+	data := make([]byte, size)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return err
+	}
+	if err := c.readTrailer(); err != nil {
+		return err
+	}
+	j.control = parseControlFile(string(data))
+	return nil
+}
+
+func (s *Server) receiveDataFile(c *conn, j *job, name string, size int64) error {
+	f, err := ioutil.TempFile("", "lpd-")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	j.dataFiles[name] = f.Name()
+
+	if size == 0 {
+		// the length of the data file is unknown, it lasts until the client closes the connection
+		if _, err = io.Copy(f, idleReader{c}); err != nil {
+			return err
+		}
+		return nil
+	}
+	if _, err = io.CopyN(f, idleReader{c}, size); err != nil {
+		return err
+	}
+	return c.readTrailer()
+}
+
+// submit hands the data files of a completely received job to the queue, one monitor job per data file.
+func (s *Server) submit(c *conn, queue *monitor.Queue, j *job) error {
+	if j.control == nil {
+		if len(j.dataFiles) > 0 {
+			return fmt.Errorf("Received data files without a control file")
+		}
+		return nil
+	}
+
+	info := monitor.JobInfo{
+		User:  j.control.user,
+		Host:  j.control.host,
+		Title: j.control.title(),
+	}
+	if info.Host == "" {
+		info.Host, _, _ = net.SplitHostPort(c.RemoteAddr().String())
+	}
+
+	for _, name := range j.control.dataFiles {
+		file, found := j.dataFiles[name]
+		if !found {
+			return fmt.Errorf("Control file references missing data file %s", name)
+		}
+		err := func() error {
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			mj, err := queue.Receive(f, info)
+			if err != nil {
+				return err
+			}
+			log.Infof("Received LPD job %s from %s@%s for queue %s", mj.Name, info.User, info.Host, queue.Name())
+			return nil
+		}()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package lpd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/smuething/devicemonitor/monitor"
+)
+
+func startMonitor(t *testing.T) *monitor.Monitor {
+	t.Helper()
+	m := monitor.NewMonitor(t.TempDir(), nil)
+	if _, err := m.AddTCPPort("NET", "net.txt", "127.0.0.1:0", "net"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Start(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Monitor failed: %s", err)
+		}
+	})
+	for deadline := time.Now().Add(5 * time.Second); m.Status().State != monitor.StateRunning; {
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout waiting for the monitor to start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return m
+}
+
+// session runs the server on one end of a pipe and returns the other end.
+func session(t *testing.T, s *Server) (net.Conn, <-chan error) {
+	t.Helper()
+	client, server := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- s.serve(server)
+		server.Close()
+	}()
+	t.Cleanup(func() { client.Close() })
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	return client, done
+}
+
+// send writes a command or file and returns the reply of the server.
+func send(t *testing.T, c net.Conn, data string) byte {
+	t.Helper()
+	if _, err := c.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 1)
+	if _, err := c.Read(reply); err != nil {
+		t.Fatal(err)
+	}
+	return reply[0]
+}
+
+func sendFile(t *testing.T, c net.Conn, subcommand byte, name string, data string) {
+	t.Helper()
+	if reply := send(t, c, fmt.Sprintf("%c%d %s\n", subcommand, len(data), name)); reply != ack {
+		t.Fatalf("file %s rejected", name)
+	}
+	if reply := send(t, c, data+"\x00"); reply != ack {
+		t.Fatalf("contents of file %s rejected", name)
+	}
+}
+
+func TestReceiveJob(t *testing.T) {
+	m := startMonitor(t)
+	s := NewServer(m, map[string]string{"raw": "NET"})
+	c, done := session(t, s)
+
+	if reply := send(t, c, "\x02raw\n"); reply != ack {
+		t.Fatal("queue rejected")
+	}
+	// the data file may arrive before the control file
+	sendFile(t, c, subDataFile, "dfA001host", "\x1bEdata\x1bE")
+	sendFile(t, c, subControlFile, "cfA001host", "Hhost\nPalice\nJRechnung 17\nldfA001host\n")
+	c.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case j := <-m.Jobs():
+		data, err := ioutil.ReadFile(j.File)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "\x1bEdata\x1bE" || j.User != "alice" || j.Host != "host" || j.Title != "Rechnung 17" {
+			t.Errorf("got job %q from %s@%s titled %q", data, j.User, j.Host, j.Title)
+		}
+		j.Done()
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for the job")
+	}
+}
+
+func TestReceiveJobUnknownQueue(t *testing.T) {
+	s := NewServer(startMonitor(t), nil)
+	c, done := session(t, s)
+
+	if reply := send(t, c, "\x02missing\n"); reply != nack {
+		t.Errorf("unknown queue accepted")
+	}
+	if err := <-done; err == nil {
+		t.Errorf("got no error for an unknown queue")
+	}
+}
+
+func TestQueueState(t *testing.T) {
+	s := NewServer(startMonitor(t), nil)
+	c, done := session(t, s)
+
+	if _, err := c.Write([]byte("\x03net\n")); err != nil {
+		t.Fatal(err)
+	}
+	status, err := bufio.NewReader(c).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != "no entries\n" {
+		t.Errorf("got status %q", status)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,185 @@
+package monitor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// number of bytes at the end of the spool file passed to the detectors
+	spoolTailSize = 4096
+	// protocol-aware detectors wait this long after the last write before declaring a job complete
+	protocolSettleTime = 250 * time.Millisecond
+)
+
+var (
+	uec       = []byte("\x1b%-12345X")
+	pjlEOJ    = []byte("@PJL EOJ")
+	pclReset  = []byte("\x1bE")
+	pdfEOF    = []byte("%%EOF")
+	trailings = "\r\n\t \x00\x0c"
+)
+
+// SpoolStatus describes a job that is currently being spooled.
+type SpoolStatus struct {
+	Size    int64         // current size of the spool file
+	Tail    []byte        // the last bytes of the spool file
+	Idle    time.Duration // time since the last write to the spool file
+	Timeout time.Duration // idle timeout of the queue
+}
+
+// JobCompletionDetector decides whether the job that is currently being spooled is complete.
+type JobCompletionDetector interface {
+	JobComplete(s *SpoolStatus) bool
+}
+
+type JobCompletionDetectorFunc func(s *SpoolStatus) bool
+
+func (f JobCompletionDetectorFunc) JobComplete(s *SpoolStatus) bool {
+	return f(s)
+}
+
+// IdleTimeout considers a job complete once there was no write for the timeout of the queue.
+func IdleTimeout() JobCompletionDetector {
+	return JobCompletionDetectorFunc(func(s *SpoolStatus) bool {
+		return s.Idle > s.Timeout
+	})
+}
+
+// trimmedTail strips trailing whitespace and padding that some applications append after the actual job.
+func trimmedTail(s *SpoolStatus) []byte {
+	return bytes.TrimRight(s.Tail, trailings)
+}
+
+// PJLEndOfJob considers a job complete if it ends with a PJL EOJ command or with a UEC
+// that does not start the job.
+func PJLEndOfJob() JobCompletionDetector {
+	return JobCompletionDetectorFunc(func(s *SpoolStatus) bool {
+		if s.Idle < protocolSettleTime {
+			return false
+		}
+		tail := trimmedTail(s)
+		if bytes.HasSuffix(tail, uec) {
+			return s.Size > int64(len(uec))
+		}
+		// the last line of the job is an EOJ command without trailing UEC
+		line := tail[bytes.LastIndexAny(tail, "\r\n")+1:]
+		return bytes.HasPrefix(line, pjlEOJ)
+	})
+}
+
+// PCLReset considers a job complete if it ends with a printer reset (ESC E).
+func PCLReset() JobCompletionDetector {
+	return JobCompletionDetectorFunc(func(s *SpoolStatus) bool {
+		if s.Idle < protocolSettleTime {
+			return false
+		}
+		// most jobs also start with a reset, which does not count
+		return s.Size > int64(len(pclReset)) && bytes.HasSuffix(trimmedTail(s), pclReset)
+	})
+}
+
+// PDFEndOfFile considers a job complete if it ends with the %%EOF marker of a PDF file.
+func PDFEndOfFile() JobCompletionDetector {
+	return JobCompletionDetectorFunc(func(s *SpoolStatus) bool {
+		if s.Idle < protocolSettleTime {
+			return false
+		}
+		return bytes.HasSuffix(trimmedTail(s), pdfEOF)
+	})
+}
+
+// AnyOf considers a job complete as soon as one of the detectors does.
+func AnyOf(detectors ...JobCompletionDetector) JobCompletionDetector {
+	return JobCompletionDetectorFunc(func(s *SpoolStatus) bool {
+		for _, d := range detectors {
+			if d.JobComplete(s) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// WithTimeoutFallback wraps a detector and completes the job anyway once there was no write
+// for the given fallback timeout. This keeps jobs that never send their end marker from
+// blocking the queue.
+func WithTimeoutFallback(detector JobCompletionDetector, fallback time.Duration) JobCompletionDetector {
+	return JobCompletionDetectorFunc(func(s *SpoolStatus) bool {
+		return detector.JobComplete(s) || s.Idle > fallback
+	})
+}
+
+// NewJobCompletionDetector builds a detector from a list of strategy names as used in the
+// configuration: timeout, pjl, pcl and pdf. The job is complete as soon as one of the strategies
+// says so. If fallback is positive, the job is also considered complete after that much idle time.
+// Otherwise a job that never sends its end marker is completed after the timeout of the queue.
+func NewJobCompletionDetector(strategies []string, fallback time.Duration) (JobCompletionDetector, error) {
+	if len(strategies) == 0 {
+		strategies = []string{"timeout"}
+	}
+	detectors := make([]JobCompletionDetector, 0, len(strategies)+1)
+	timeout := false
+	for _, strategy := range strategies {
+		switch strings.ToLower(strategy) {
+		case "timeout":
+			detectors = append(detectors, IdleTimeout())
+			timeout = true
+		case "pjl":
+			detectors = append(detectors, PJLEndOfJob())
+		case "pcl":
+			detectors = append(detectors, PCLReset())
+		case "pdf":
+			detectors = append(detectors, PDFEndOfFile())
+		default:
+			return nil, fmt.Errorf("Unknown job completion strategy: %s", strategy)
+		}
+	}
+	if !timeout && fallback <= 0 {
+		detectors = append(detectors, IdleTimeout())
+	}
+	var detector JobCompletionDetector
+	if len(detectors) == 1 {
+		detector = detectors[0]
+	} else {
+		detector = AnyOf(detectors...)
+	}
+	if fallback > 0 {
+		detector = WithTimeoutFallback(detector, fallback)
+	}
+	return detector, nil
+}
+
+// readSpoolStatus collects the current state of the spool file for the detectors.
+func readSpoolStatus(file string) (*SpoolStatus, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readTail(f)
+}
+
+func readTail(f *os.File) (*SpoolStatus, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SpoolStatus{Size: fi.Size()}
+	offset := s.Size - spoolTailSize
+	if offset < 0 {
+		offset = 0
+	}
+	s.Tail = make([]byte, s.Size-offset)
+	n, err := f.ReadAt(s.Tail, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	s.Tail = s.Tail[:n]
+	return s, nil
+}
@@ -0,0 +1,113 @@
+package monitor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func spoolStatus(data string, idle time.Duration) *SpoolStatus {
+	return &SpoolStatus{
+		Size:    int64(len(data)),
+		Tail:    []byte(data),
+		Idle:    idle,
+		Timeout: time.Second,
+	}
+}
+
+func TestJobCompletionDetectors(t *testing.T) {
+	const settled = time.Second
+	const busy = 10 * time.Millisecond
+
+	tests := []struct {
+		name       string
+		strategies []string
+		fallback   time.Duration
+		data       string
+		idle       time.Duration
+		want       bool
+	}{
+		{"timeout idle", nil, 0, "data", 2 * time.Second, true},
+		{"timeout busy", []string{"timeout"}, 0, "data", 500 * time.Millisecond, false},
+		{"pjl uec", []string{"pjl"}, 0, "\x1b%-12345X@PJL\r\n\x1bEdata\x1b%-12345X", settled, true},
+		{"pjl uec with padding", []string{"pjl"}, 0, "\x1b%-12345Xdata\x1b%-12345X\r\n\x00\x00", settled, true},
+		{"pjl starting uec", []string{"pjl"}, 0, "\x1b%-12345X", settled, false},
+		{"pjl eoj", []string{"PJL"}, 0, "\x1b%-12345Xdata\r\n@PJL EOJ NAME=\"a\"\r\n", settled, true},
+		{"pjl eoj not last", []string{"pjl"}, 0, "@PJL EOJ\r\nmore data", settled, false},
+		{"pjl not settled", []string{"pjl"}, 0, "data\x1b%-12345X", busy, false},
+		{"pcl reset", []string{"pcl"}, 0, "\x1bEdata\x1bE\f", settled, true},
+		{"pcl starting reset", []string{"pcl"}, 0, "\x1bE", settled, false},
+		{"pcl open", []string{"pcl"}, 0, "\x1bEdata", settled, false},
+		{"pcl not settled", []string{"pcl"}, 0, "\x1bEdata\x1bE", busy, false},
+		{"pdf eof", []string{"pdf"}, 0, "%PDF-1.4\n%%EOF\n", settled, true},
+		{"pdf open", []string{"pdf"}, 0, "%PDF-1.4\n", settled, false},
+		{"any of", []string{"pdf", "pcl"}, 0, "\x1bEdata\x1bE", settled, true},
+		{"none of", []string{"pdf", "pcl"}, 0, "\x1bEdata", settled, false},
+		{"fallback", []string{"pcl"}, time.Minute, "\x1bEdata", 2 * time.Minute, true},
+		{"before fallback", []string{"pcl"}, time.Minute, "\x1bEdata", 30 * time.Second, false},
+		{"pcl open after timeout", []string{"pcl"}, 0, "\x1bEdata", 2 * time.Second, true},
+		{"pdf open after timeout", []string{"pdf", "pjl"}, 0, "%PDF-1.4\n", 2 * time.Second, true},
+		{"fallback replaces timeout", []string{"pcl"}, time.Minute, "\x1bEdata", 2 * time.Second, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detector, err := NewJobCompletionDetector(tt.strategies, tt.fallback)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := detector.JobComplete(spoolStatus(tt.data, tt.idle)); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewJobCompletionDetectorInvalid(t *testing.T) {
+	if _, err := NewJobCompletionDetector([]string{"pcl", "magic"}, 0); err == nil {
+		t.Errorf("got no error for an unknown strategy")
+	}
+}
+
+func TestSubmitJobInFlight(t *testing.T) {
+	dir := t.TempDir()
+	m := NewMonitor(dir, nil)
+	release := make(chan bool)
+	var validations int32
+	q := &Queue{
+		Device:  "LPT1",
+		File:    filepath.Join(dir, "lpt1.txt"),
+		monitor: m,
+		validator: func(f *os.File) bool {
+			atomic.AddInt32(&validations, 1)
+			return <-release
+		},
+	}
+	if err := ioutil.WriteFile(q.File, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	q.job = &Job{Name: "pj-test", File: filepath.Join(dir, "pj-test.prn"), queue: q, monitor: m}
+
+	// the ticker completes the job again while its validation is still running
+	for i := 0; i < 3; i++ {
+		q.submitJob()
+	}
+	release <- false
+	waitFor(t, "validation to finish", func() bool {
+		q.m.Lock()
+		defer q.m.Unlock()
+		return !q.submitting
+	})
+	if n := atomic.LoadInt32(&validations); n != 1 {
+		t.Errorf("validated the job %d times, want 1", n)
+	}
+
+	q.submitJob()
+	release <- false
+	if n := atomic.LoadInt32(&validations); n != 2 {
+		t.Errorf("did not validate the job again once the first attempt was over")
+	}
+}
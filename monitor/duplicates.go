@@ -0,0 +1,144 @@
+package monitor
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DuplicateAction determines what happens to a job that is identical to a recent job of the same queue.
+type DuplicateAction int
+
+const (
+	DuplicateAllow DuplicateAction = iota
+	DuplicateHold
+	DuplicateDrop
+)
+
+func (a DuplicateAction) String() string {
+	switch a {
+	case DuplicateAllow:
+		return "allow"
+	case DuplicateHold:
+		return "hold"
+	case DuplicateDrop:
+		return "drop"
+	default:
+		return fmt.Sprintf("UNKNOWN DUPLICATE ACTION: %d", a)
+	}
+}
+
+func ParseDuplicateAction(s string) (DuplicateAction, error) {
+	switch strings.ToLower(s) {
+	case "", "allow":
+		return DuplicateAllow, nil
+	case "hold":
+		return DuplicateHold, nil
+	case "drop":
+		return DuplicateDrop, nil
+	default:
+		return DuplicateAllow, fmt.Errorf("Unknown duplicate action: %s", s)
+	}
+}
+
+// DuplicatePolicy configures the detection of jobs that were printed twice by accident.
+type DuplicatePolicy struct {
+	Window time.Duration // a job is a duplicate if an identical job completed less than this ago
+	Action DuplicateAction
+	// Normalize can strip parts of the data that differ between otherwise identical jobs,
+	// like PJL job names with timestamps. The data is compared byte by byte if it is nil.
+	Normalize func(data []byte) []byte
+}
+
+func (p DuplicatePolicy) enabled() bool {
+	return p.Window > 0 && p.Action != DuplicateAllow
+}
+
+type seenJob struct {
+	name string
+	hash [sha256.Size]byte
+	time time.Time
+}
+
+// SetDuplicatePolicy configures how the queue deals with duplicate jobs.
+func (q *Queue) SetDuplicatePolicy(policy DuplicatePolicy) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.duplicates = policy
+	if !policy.enabled() {
+		q.seen = nil
+	}
+}
+
+// duplicateOf returns the name of a recent job of the queue with the same content or an empty string.
+func (q *Queue) duplicateOf(j *Job) (string, DuplicateAction) {
+	q.m.Lock()
+	policy := q.duplicates
+	q.m.Unlock()
+
+	if !policy.enabled() {
+		return "", DuplicateAllow
+	}
+
+	data, err := ioutil.ReadFile(j.File)
+	if err != nil {
+		log.Errorf("Could not check job %s for duplicates: %s", j.Name, err)
+		return "", DuplicateAllow
+	}
+	if policy.Normalize != nil {
+		data = policy.Normalize(data)
+	}
+	hash := sha256.Sum256(data)
+	now := time.Now()
+
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	recent := q.seen[:0]
+	for _, s := range q.seen {
+		if now.Sub(s.time) < policy.Window {
+			recent = append(recent, s)
+		}
+	}
+	q.seen = recent
+	for _, s := range q.seen {
+		if s.hash == hash {
+			return s.name, policy.Action
+		}
+	}
+	// duplicates do not extend the window of the original job
+	q.seen = append(q.seen, seenJob{name: j.Name, hash: hash, time: now})
+	return "", DuplicateAllow
+}
+
+// handOff passes a completed job to the work queue unless it is a duplicate that has to be dropped.
+// Duplicates that are held wait in the work queue until they are released.
+func (q *Queue) handOff(j *Job) error {
+	original, action := q.duplicateOf(j)
+	if original != "" {
+		j.rm.Lock()
+		j.record.DuplicateOf = original
+		j.rm.Unlock()
+		log.Warnf("Job %s of queue %s is a duplicate of job %s (%s)", j.Name, q.Name(), original, action)
+		q.publish(JobDuplicate, j, fileSize(j.File), fmt.Errorf("duplicate of job %s", original))
+		if action == DuplicateDrop {
+			q.monitor.journal.record(j, JobCancelled, fmt.Errorf("duplicate of job %s", original))
+			return nil
+		}
+		// keep the original in the journal
+		q.monitor.journal.record(j, JobSubmitted, nil)
+		j.held = true
+		j.duplicate = true
+	}
+
+	// This might block if the work queue is full, so we must not hold the lock
+	err := q.monitor.work.put(j, false)
+	if err != nil {
+		q.publish(JobDropped, j, fileSize(j.File), err)
+	}
+	return err
+}
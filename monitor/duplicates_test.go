@@ -0,0 +1,131 @@
+package monitor
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDuplicateOf(t *testing.T) {
+	stripName := func(data []byte) []byte {
+		if i := bytes.IndexByte(data, '|'); i >= 0 {
+			return data[i+1:]
+		}
+		return data
+	}
+
+	tests := []struct {
+		name   string
+		policy DuplicatePolicy
+		jobs   []string
+		want   []string // the name of the original job for each job, empty if it is not a duplicate
+	}{
+		{
+			name:   "disabled without window",
+			policy: DuplicatePolicy{Action: DuplicateHold},
+			jobs:   []string{"a", "a"},
+			want:   []string{"", ""},
+		},
+		{
+			name:   "disabled by allow",
+			policy: DuplicatePolicy{Window: time.Minute, Action: DuplicateAllow},
+			jobs:   []string{"a", "a"},
+			want:   []string{"", ""},
+		},
+		{
+			name:   "identical data",
+			policy: DuplicatePolicy{Window: time.Minute, Action: DuplicateHold},
+			jobs:   []string{"a", "b", "a", "b", "a"},
+			want:   []string{"", "", "job-0", "job-1", "job-0"},
+		},
+		{
+			name:   "different data",
+			policy: DuplicatePolicy{Window: time.Minute, Action: DuplicateDrop},
+			jobs:   []string{"1|a", "2|a"},
+			want:   []string{"", ""},
+		},
+		{
+			name:   "normalized data",
+			policy: DuplicatePolicy{Window: time.Minute, Action: DuplicateDrop, Normalize: stripName},
+			jobs:   []string{"1|a", "2|a", "3|b"},
+			want:   []string{"", "job-0", ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			q := &Queue{}
+			q.SetDuplicatePolicy(tt.policy)
+			for i, data := range tt.jobs {
+				j := &Job{Name: fmt.Sprintf("job-%d", i), File: filepath.Join(dir, fmt.Sprintf("job-%d", i))}
+				if err := ioutil.WriteFile(j.File, []byte(data), 0644); err != nil {
+					t.Fatal(err)
+				}
+				original, action := q.duplicateOf(j)
+				if original != tt.want[i] {
+					t.Errorf("job %d: got duplicate of %q, want %q", i, original, tt.want[i])
+				}
+				wantAction := DuplicateAllow
+				if original != "" {
+					wantAction = tt.policy.Action
+				}
+				if action != wantAction {
+					t.Errorf("job %d: got action %s, want %s", i, action, wantAction)
+				}
+			}
+		})
+	}
+}
+
+func TestDuplicateWindow(t *testing.T) {
+	dir := t.TempDir()
+	q := &Queue{}
+	q.SetDuplicatePolicy(DuplicatePolicy{Window: time.Minute, Action: DuplicateHold})
+
+	job := func(name string) *Job {
+		j := &Job{Name: name, File: filepath.Join(dir, name)}
+		if err := ioutil.WriteFile(j.File, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return j
+	}
+
+	q.duplicateOf(job("first"))
+	if original, _ := q.duplicateOf(job("second")); original != "first" {
+		t.Errorf("got duplicate of %q within the window", original)
+	}
+
+	// the original job left the window, the duplicate did not extend it
+	q.seen[0].time = time.Now().Add(-2 * time.Minute)
+	if original, _ := q.duplicateOf(job("third")); original != "" {
+		t.Errorf("got duplicate of %q after the window", original)
+	}
+	if original, _ := q.duplicateOf(job("fourth")); original != "third" {
+		t.Errorf("got duplicate of %q, want third", original)
+	}
+}
+
+func TestParseDuplicateAction(t *testing.T) {
+	tests := []struct {
+		s    string
+		want DuplicateAction
+		err  bool
+	}{
+		{"", DuplicateAllow, false},
+		{"allow", DuplicateAllow, false},
+		{"Hold", DuplicateHold, false},
+		{"DROP", DuplicateDrop, false},
+		{"delete", DuplicateAllow, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseDuplicateAction(tt.s)
+		if got != tt.want || (err != nil) != tt.err {
+			t.Errorf("ParseDuplicateAction(%q) = %s, %v", tt.s, got, err)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package monitor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type EventType int
+
+const (
+	QueueStarted EventType = iota
+	QueueStopped
+	QueueError
+	JobStarted
+	JobProgress
+	JobCompleted
+	JobDropped
+	JobHeld
+	JobDuplicate
+)
+
+func (t EventType) String() string {
+	switch t {
+	case QueueStarted:
+		return "queue started"
+	case QueueStopped:
+		return "queue stopped"
+	case QueueError:
+		return "queue error"
+	case JobStarted:
+		return "job started"
+	case JobProgress:
+		return "job progress"
+	case JobCompleted:
+		return "job completed"
+	case JobDropped:
+		return "job dropped"
+	case JobHeld:
+		return "job held"
+	case JobDuplicate:
+		return "job duplicate"
+	default:
+		return fmt.Sprintf("UNKNOWN EVENT TYPE: %d", t)
+	}
+}
+
+// Event describes a change in the lifecycle of a queue or a job. Job and Size are only set for
+// job events, Size is the number of bytes spooled so far. Err is set for QueueError and JobDropped,
+// for JobDuplicate it names the original job.
+type Event struct {
+	Type   EventType
+	Time   time.Time
+	Device string
+	Queue  string
+	Job    string
+	Size   int64
+	Err    error
+}
+
+func (e Event) String() string {
+	s := fmt.Sprintf("%s: device %s", e.Type, e.Device)
+	if e.Job != "" {
+		s += fmt.Sprintf(", job %s (%d bytes)", e.Job, e.Size)
+	}
+	if e.Err != nil {
+		s += fmt.Sprintf(": %s", e.Err)
+	}
+	return s
+}
+
+// Subscription delivers the events of a monitor in the order they happened. Events are buffered
+// without limit, so a slow subscriber never loses events and never holds up the monitor.
+type Subscription struct {
+	m       sync.Mutex
+	broker  *eventBroker
+	pending []Event
+	closed  bool
+	wake    chan struct{}
+	cancel  sync.Once
+	done    chan struct{}
+	events  chan Event
+}
+
+// Events returns the channel the events are delivered on. It is closed after the monitor has
+// stopped and all events have been delivered, or when the subscription is cancelled.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Cancel ends the subscription, events that have not been delivered yet are discarded.
+func (s *Subscription) Cancel() {
+	s.broker.unsubscribe(s)
+	s.cancel.Do(func() {
+		s.m.Lock()
+		defer s.m.Unlock()
+		s.closed = true
+		s.pending = nil
+		close(s.done)
+	})
+}
+
+func (s *Subscription) push(e Event) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.closed {
+		return
+	}
+	s.pending = append(s.pending, e)
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// finish closes the subscription once all pending events have been delivered.
+func (s *Subscription) finish() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if !s.closed {
+		s.closed = true
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *Subscription) run() {
+	defer close(s.events)
+	for {
+		s.m.Lock()
+		if len(s.pending) == 0 {
+			closed := s.closed
+			s.m.Unlock()
+			if closed {
+				return
+			}
+			select {
+			case <-s.wake:
+			case <-s.done:
+				return
+			}
+			continue
+		}
+		e := s.pending[0]
+		s.pending[0] = Event{}
+		s.pending = s.pending[1:]
+		s.m.Unlock()
+
+		select {
+		case s.events <- e:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+type eventBroker struct {
+	m           sync.Mutex
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+func (b *eventBroker) subscribe() *Subscription {
+	s := &Subscription{
+		broker: b,
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		events: make(chan Event),
+	}
+	go s.run()
+
+	b.m.Lock()
+	defer b.m.Unlock()
+	if b.closed {
+		s.finish()
+	} else {
+		b.subscribers[s] = struct{}{}
+	}
+	return s
+}
+
+func (b *eventBroker) unsubscribe(s *Subscription) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	delete(b.subscribers, s)
+}
+
+func (b *eventBroker) publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.m.Lock()
+	defer b.m.Unlock()
+	for s := range b.subscribers {
+		s.push(e)
+	}
+}
+
+func (b *eventBroker) close() {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.closed = true
+	for s := range b.subscribers {
+		s.finish()
+	}
+	b.subscribers = make(map[*Subscription]struct{})
+}
+
+// Subscribe registers a new subscriber for the lifecycle events of the monitor. Subscribers must
+// either drain Events() or cancel the subscription.
+func (m *Monitor) Subscribe() *Subscription {
+	return m.events.subscribe()
+}
+
+func (q *Queue) publish(t EventType, j *Job, size int64, err error) {
+	e := Event{
+		Type:   t,
+		Device: q.Device,
+		Queue:  filepath.Base(q.File),
+		Size:   size,
+		Err:    err,
+	}
+	if j != nil {
+		e.Job = j.Name
+	}
+	q.monitor.events.publish(e)
+}
+
+// publishError reports an error of the queue to the subscribers and returns it.
+func (q *Queue) publishError(err error) error {
+	q.recordError(err)
+	q.publish(QueueError, nil, 0, err)
+	return err
+}
+
+func fileSize(file string) int64 {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
@@ -0,0 +1,190 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var errJobCancelled = errors.New("cancelled by operator")
+
+// setHold parks the jobs of a queue or releases all of its parked jobs.
+func (w *workQueue) setHold(queueFile string, hold bool) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if hold {
+		w.held[queueFile] = true
+		for _, j := range w.pending[queueFile] {
+			j.held = true
+		}
+		// held jobs make room for the jobs of other queues
+		w.space.Broadcast()
+		return
+	}
+	delete(w.held, queueFile)
+	for _, j := range w.pending[queueFile] {
+		// duplicates have to be released one by one
+		j.held = j.duplicate
+	}
+	w.signal()
+}
+
+func (w *workQueue) publishHeld(j *Job) {
+	w.monitor.events.publish(Event{
+		Type:   JobHeld,
+		Device: j.Device,
+		Queue:  j.queueFile,
+		Job:    j.Name,
+		Size:   fileSize(j.File),
+	})
+}
+
+func (w *workQueue) isHeld(queueFile string) bool {
+	w.m.Lock()
+	defer w.m.Unlock()
+	return w.held[queueFile]
+}
+
+// release hands a single parked job to the consumer, even if its queue remains on hold.
+func (w *workQueue) release(name string) error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	for _, device := range w.devices {
+		for _, j := range w.pending[device] {
+			if j.Name == name {
+				j.held = false
+				j.duplicate = false
+				w.signal()
+				return nil
+			}
+		}
+	}
+	j, err := w.takeSpilled(name)
+	if err != nil {
+		return err
+	}
+	j.held = false
+	j.duplicate = false
+	w.push(j)
+	return nil
+}
+
+// take removes a job that has not been handed to the consumer yet from the queue.
+func (w *workQueue) take(name string) (*Job, error) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	for _, j := range w.active {
+		if j.Name == name {
+			return nil, fmt.Errorf("Job %s is already being processed", name)
+		}
+	}
+	for _, device := range w.devices {
+		for k, j := range w.pending[device] {
+			if j.Name == name {
+				w.pending[device] = append(w.pending[device][:k], w.pending[device][k+1:]...)
+				w.count--
+				w.space.Broadcast()
+				return j, nil
+			}
+		}
+	}
+	return w.takeSpilled(name)
+}
+
+func (w *workQueue) takeSpilled(name string) (*Job, error) {
+	for k, path := range w.spilled {
+		if filepath.Base(path) != name+".json" {
+			continue
+		}
+		j, err := w.loadSpilled(path)
+		if err != nil {
+			return nil, err
+		}
+		os.Remove(path)
+		w.spilled = append(w.spilled[:k], w.spilled[k+1:]...)
+		return j, nil
+	}
+	return nil, fmt.Errorf("Unknown job: %s", name)
+}
+
+// SetHold puts the queue on hold or releases it. Jobs of a queue on hold are still captured and
+// completed, but they are parked instead of being handed to the consumer until they are released.
+// Releasing the queue releases all of its parked jobs.
+func (q *Queue) SetHold(hold bool) {
+	if hold != q.Held() {
+		if hold {
+			log.Infof("Holding jobs of queue %s", q.Name())
+		} else {
+			log.Infof("Releasing jobs of queue %s", q.Name())
+		}
+	}
+	q.monitor.work.setHold(filepath.Base(q.File), hold)
+}
+
+// Held reports whether the queue is on hold.
+func (q *Queue) Held() bool {
+	return q.monitor.work.isHeld(filepath.Base(q.File))
+}
+
+// ReleaseJob hands a parked job to the consumer. The queue of the job stays on hold.
+func (m *Monitor) ReleaseJob(name string) error {
+	if err := m.work.release(name); err != nil {
+		return err
+	}
+	log.Infof("Released job %s", name)
+	return nil
+}
+
+// CancelJob removes a job that has not been processed yet. Its files are kept until the
+// retention policy removes them.
+func (m *Monitor) CancelJob(name string) error {
+	j, err := m.work.take(name)
+	if err != nil {
+		return err
+	}
+	log.Infof("Cancelled job %s", name)
+	m.journal.record(j, JobCancelled, errJobCancelled)
+	m.events.publish(Event{
+		Type:   JobDropped,
+		Device: j.Device,
+		Queue:  j.queueFile,
+		Job:    j.Name,
+		Size:   fileSize(j.File),
+		Err:    errJobCancelled,
+	})
+	return nil
+}
+
+// MoveJob moves a job that has not been processed yet to the queue of another device. The job
+// is parked if that queue is on hold or if it is a duplicate that has not been released.
+func (m *Monitor) MoveJob(name string, device string) error {
+	queue := m.Queue(device)
+	if queue == nil {
+		return fmt.Errorf("Cannot move job %s to %s, not monitoring", name, device)
+	}
+	j, err := m.work.take(name)
+	if err != nil {
+		return err
+	}
+
+	// the hold of the old queue does not apply anymore
+	j.held = j.duplicate
+	j.queue = queue
+	j.queueFile = filepath.Base(queue.File)
+	j.Device = queue.Device
+	j.Printer = queue.Settings.Get("printer")
+	j.rm.Lock()
+	j.record.Device = queue.Device
+	j.record.Queue = queue.Name()
+	j.rm.Unlock()
+
+	log.Infof("Moved job %s to device %s", name, queue.Device)
+	m.journal.record(j, JobSubmitted, nil)
+	return m.work.put(j, true)
+}
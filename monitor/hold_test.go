@@ -0,0 +1,154 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+)
+
+// startHoldQueues starts a monitor with two network queues, A is on hold.
+func startHoldQueues(t *testing.T) (*Monitor, *Subscription, map[string]string) {
+	t.Helper()
+	m := NewMonitor(t.TempDir(), nil)
+	addresses := make(map[string]string)
+	for _, device := range []string{"A", "B"} {
+		q, err := m.AddTCPPort(device, device+".txt", "127.0.0.1:0", device)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q.SetTimeout(time.Second)
+	}
+	m.Queue("A").SetHold(true)
+	runMonitor(t, m)
+	for _, q := range m.Queues() {
+		q.m.Lock()
+		addresses[q.Device] = q.binder.(*tcpBinder).listener.Addr().String()
+		q.m.Unlock()
+	}
+	events := m.Subscribe()
+	t.Cleanup(events.Cancel)
+	return m, events, addresses
+}
+
+// heldJob sends a job to the held queue and waits for it to be parked.
+func heldJob(t *testing.T, events *Subscription, address string, data string) string {
+	t.Helper()
+	sendTCP(t, address, data).Close()
+	return nextEvent(t, events, JobHeld).Job
+}
+
+func noJob(t *testing.T, m *Monitor) {
+	t.Helper()
+	select {
+	case j := <-m.Jobs():
+		t.Fatalf("got job %s from a queue on hold", j.Name)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func jobState(m *Monitor, name string) JobState {
+	for _, r := range m.JobRecords() {
+		if r.ID == name {
+			return r.Status
+		}
+	}
+	return invalidJobState
+}
+
+func TestHoldAndRelease(t *testing.T) {
+	m, events, addresses := startHoldQueues(t)
+
+	first := heldJob(t, events, addresses["A"], "first")
+	second := heldJob(t, events, addresses["A"], "second")
+	noJob(t, m)
+	if queued := m.QueuedJobs(); len(queued) != 2 || !queued[0].Held || !queued[1].Held {
+		t.Errorf("got queued jobs %+v", queued)
+	}
+
+	// releasing a single job leaves the queue on hold
+	if err := m.ReleaseJob(second); err != nil {
+		t.Fatal(err)
+	}
+	j := nextJob(t, m)
+	if j.Name != second {
+		t.Errorf("got job %s, want %s", j.Name, second)
+	}
+	j.Done()
+	noJob(t, m)
+	if !m.Queue("A").Held() {
+		t.Errorf("queue is no longer on hold")
+	}
+
+	m.Queue("A").SetHold(false)
+	if j = nextJob(t, m); j.Name != first {
+		t.Errorf("got job %s, want %s", j.Name, first)
+	}
+	j.Done()
+
+	if err := m.ReleaseJob("pj-unknown"); err == nil {
+		t.Errorf("released an unknown job")
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	m, events, addresses := startHoldQueues(t)
+
+	name := heldJob(t, events, addresses["A"], "data")
+	if err := m.CancelJob(name); err != nil {
+		t.Fatal(err)
+	}
+	if e := nextEvent(t, events, JobDropped); e.Job != name || e.Err != errJobCancelled {
+		t.Errorf("got event %+v", e)
+	}
+	if state := jobState(m, name); state != JobCancelled {
+		t.Errorf("cancelled job is %s", state)
+	}
+	m.Queue("A").SetHold(false)
+	noJob(t, m)
+	if err := m.CancelJob(name); err == nil {
+		t.Errorf("cancelled a job twice")
+	}
+
+	// jobs that are being processed cannot be cancelled
+	sendTCP(t, addresses["B"], "data").Close()
+	j := nextJob(t, m)
+	if err := m.CancelJob(j.Name); err == nil {
+		t.Errorf("cancelled an active job")
+	}
+	j.Done()
+}
+
+func TestMoveJob(t *testing.T) {
+	m, events, addresses := startHoldQueues(t)
+
+	name := heldJob(t, events, addresses["A"], "data")
+	if err := m.MoveJob(name, "C"); err == nil {
+		t.Errorf("moved a job to an unknown device")
+	}
+	if err := m.MoveJob(name, "b"); err != nil {
+		t.Fatal(err)
+	}
+	j := nextJob(t, m)
+	if j.Name != name || j.Device != "B" || j.Record().Queue != "B" {
+		t.Errorf("got job %s for device %s, queue %s", j.Name, j.Device, j.Record().Queue)
+	}
+	if got := jobData(t, j); got != "data" {
+		t.Errorf("got job %q", got)
+	}
+	j.Done()
+
+	if err := m.MoveJob(name, "A"); err == nil {
+		t.Errorf("moved a finished job")
+	}
+
+	// moving a job onto a queue on hold parks it there
+	m.Queue("B").SetHold(true)
+	name = heldJob(t, events, addresses["B"], "back")
+	if err := m.MoveJob(name, "A"); err != nil {
+		t.Fatal(err)
+	}
+	if e := nextEvent(t, events, JobHeld); e.Job != name || e.Device != "A" {
+		t.Errorf("got event %+v", e)
+	}
+	m.Queue("B").SetHold(false)
+	noJob(t, m)
+}
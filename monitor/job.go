@@ -1,16 +1,148 @@
 package monitor
 
 import (
+	"os"
 	"sync"
 	"time"
+
+	log "github.com/sirupsen/logrus"
 )
 
 type Job struct {
 	m         sync.Mutex
 	Time      time.Time
 	Name      string
+	Device    string
 	queue     *Queue
+	queueFile string
 	File      string
 	Printer   string
+	User      string // only known for jobs received over the network
+	Host      string
+	Title     string
+	Recovered bool // the job was interrupted by a crash or shutdown and picked up again
 	submitted bool
+	linked    bool // the job file has been linked to the spool file
+	held      bool // parked in the work queue until it is released, protected by the lock of the work queue once the job has been handed to it
+	duplicate bool // held as a duplicate, independent of the hold of its queue
+	monitor   *Monitor
+	rm        sync.Mutex
+	record    JobRecord
+}
+
+// Processing marks the job as being processed by the consumer.
+func (j *Job) Processing() {
+	j.monitor.journal.record(j, JobProcessing, nil)
+}
+
+// Done marks the job as successfully processed. Consumers of Monitor.Jobs() must call
+// either Done or Failed for every job, the next job of the same device is held back until then.
+func (j *Job) Done() {
+	j.monitor.journal.record(j, JobDone, nil)
+	j.monitor.work.finish(j)
+}
+
+// Failed marks the job as failed, it will not be recovered after a restart.
+func (j *Job) Failed(err error) {
+	j.monitor.journal.record(j, JobFailed, err)
+	j.monitor.work.finish(j)
+}
+
+func (j *Job) journalEntry(state JobState, err error) *journalEntry {
+	r := j.Record()
+	entry := &journalEntry{
+		Time:      time.Now(),
+		Job:       j.Name,
+		Queue:     j.queueFile,
+		QueueName: r.Queue,
+		Device:    j.Device,
+		File:      j.File,
+		State:     state,
+		User:      j.User,
+		Host:      j.Host,
+		Title:     j.Title,
+		Started:   j.Time,
+		Size:      r.Size,
+		Language:  r.Language,
+		Pages:     r.Pages,
+		Printer:   j.Printer,
+		Duplicate: r.DuplicateOf,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	return entry
+}
+
+func (j *Job) submit() error {
+	return j.trySubmit(true)
+}
+
+// trySubmit links the spool file to the job file and hands the job to the work queue. If validate
+// is set, the job is only submitted if the validation function of the monitor accepts it.
+func (j *Job) trySubmit(validate bool) error {
+	submitted, err := j.markSubmitted(validate)
+	if err != nil || !submitted {
+		return err
+	}
+
+	// handing off might block if the work queue is full, so we must not hold the lock
+	j.monitor.journal.record(j, JobSubmitted, nil)
+	j.monitor.updateSpooling(-1)
+	j.queue.publish(JobCompleted, j, fileSize(j.File), nil)
+	return j.queue.finishJob(j)
+}
+
+// markSubmitted links and validates the job. It reports whether the job has been submitted by this call.
+func (j *Job) markSubmitted(validate bool) (bool, error) {
+
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	if j.submitted {
+		return false, nil
+	}
+
+	// a job that was held back by the validation has already been linked, any other existing
+	// file belongs to a different job
+	if !j.linked {
+		if err := os.Link(j.queue.File, j.File); err != nil {
+			return false, err
+		}
+		j.linked = true
+	}
+
+	isValid := j.queue.jobValidator()
+	if validate && isValid != nil {
+
+		f, err := os.Open(j.File)
+		if err != nil {
+			return false, err
+		}
+		defer f.Close()
+
+		fi, err := f.Stat()
+		if err != nil {
+			return false, err
+		}
+
+		if isValid(f) {
+			fi2, err := f.Stat()
+			if err != nil {
+				return false, err
+			}
+
+			if !fi2.ModTime().After(fi.ModTime()) {
+				j.submitted = true
+			}
+
+		} else {
+			log.Debugf("Holding back job %s, validation failed", j.Name)
+		}
+
+	} else {
+		j.submitted = true
+	}
+
+	return j.submitted, nil
 }
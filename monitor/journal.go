@@ -0,0 +1,464 @@
+package monitor
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	journalFile = "jobs.journal"
+	// the journal is rewritten with the latest entry of every job once it has grown by this many
+	// entries and most of its entries have been superseded
+	journalCompactEntries = 1000
+)
+
+var errEmptySpoolFile = errors.New("spool file is empty")
+
+type JobState int
+
+const (
+	invalidJobState JobState = iota
+	JobSpooling
+	JobSubmitted
+	JobProcessing
+	JobDone
+	JobFailed
+	JobCancelled
+)
+
+func (s JobState) String() string {
+	switch s {
+	case JobSpooling:
+		return "spooling"
+	case JobSubmitted:
+		return "submitted"
+	case JobProcessing:
+		return "processing"
+	case JobDone:
+		return "done"
+	case JobFailed:
+		return "failed"
+	case JobCancelled:
+		return "cancelled"
+	default:
+		return fmt.Sprintf("UNKNOWN JOB STATE: %d", s)
+	}
+}
+
+func (s JobState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *JobState) UnmarshalText(text []byte) error {
+	for state := JobSpooling; state <= JobCancelled; state++ {
+		if state.String() == string(text) {
+			*s = state
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown job state: %s", text)
+}
+
+// Finished reports whether a job in this state will not be touched again.
+func (s JobState) Finished() bool {
+	return s == JobDone || s == JobFailed || s == JobCancelled
+}
+
+// journalEntry records a single state transition of a job.
+type journalEntry struct {
+	Time   time.Time `json:"time"`
+	Job    string    `json:"job"`
+	Queue  string    `json:"queue"`
+	Device string    `json:"device"`
+	File   string    `json:"file"`
+	State  JobState  `json:"state"`
+	Error  string    `json:"error,omitempty"`
+	User   string    `json:"user,omitempty"`
+	Host   string    `json:"host,omitempty"`
+	Title  string    `json:"title,omitempty"`
+	// metadata of the job, see JobRecord
+	QueueName string    `json:"queue_name,omitempty"`
+	Started   time.Time `json:"started"`
+	Size      int64     `json:"size,omitempty"`
+	Language  string    `json:"language,omitempty"`
+	Pages     int       `json:"pages,omitempty"`
+	Printer   string    `json:"printer,omitempty"`
+	Duplicate string    `json:"duplicate_of,omitempty"`
+	Held      bool      `json:"held,omitempty"` // a held duplicate, only used for jobs spilled by the work queue
+}
+
+func (e *journalEntry) record() JobRecord {
+	r := JobRecord{
+		ID:          e.Job,
+		Device:      e.Device,
+		Queue:       e.QueueName,
+		File:        e.File,
+		Size:        e.Size,
+		Started:     e.Started,
+		Language:    e.Language,
+		Pages:       e.Pages,
+		Printer:     e.Printer,
+		User:        e.User,
+		Host:        e.Host,
+		Title:       e.Title,
+		Status:      e.State,
+		Error:       e.Error,
+		DuplicateOf: e.Duplicate,
+	}
+	if e.State.Finished() {
+		r.Finished = e.Time
+	}
+	return r
+}
+
+// journal is an append-only log of job state transitions in the spool directory. It allows
+// the monitor to find jobs that were interrupted by a crash and pick them up again.
+type journal struct {
+	m        sync.Mutex
+	path     string
+	f        *os.File
+	jobs     map[string]*journalEntry // latest entry of every job in the journal
+	appended int                      // entries appended since the journal was last rewritten
+}
+
+func newJournal(dir string) *journal {
+	return &journal{
+		path: filepath.Join(dir, journalFile),
+		jobs: make(map[string]*journalEntry),
+	}
+}
+
+// load returns the latest entry of every job in the journal, in the order the jobs were first recorded.
+func (jl *journal) load() ([]*journalEntry, error) {
+	f, err := os.Open(jl.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	latest := make(map[string]*journalEntry)
+	order := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		entry := &journalEntry{}
+		if err := json.Unmarshal([]byte(line), entry); err != nil {
+			// a crash can leave a partial line at the end of the journal
+			log.Warnf("Skipping corrupt journal entry: %s", line)
+			continue
+		}
+		if _, found := latest[entry.Job]; !found {
+			order = append(order, entry.Job)
+		}
+		latest[entry.Job] = entry
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	entries := make([]*journalEntry, 0, len(order))
+	for _, job := range order {
+		entries = append(entries, latest[job])
+	}
+	return entries, nil
+}
+
+// open rewrites the journal with the given entries and opens it for appending.
+func (jl *journal) open(entries []*journalEntry) error {
+	jl.m.Lock()
+	defer jl.m.Unlock()
+
+	if jl.f != nil {
+		return fmt.Errorf("Journal %s is already open", jl.path)
+	}
+	jl.jobs = make(map[string]*journalEntry, len(entries))
+	for _, entry := range entries {
+		jl.jobs[entry.Job] = entry
+	}
+	return jl.rewriteWhileLocked(entries)
+}
+
+// rewriteWhileLocked replaces the journal file with the given entries and opens it for appending.
+func (jl *journal) rewriteWhileLocked(entries []*journalEntry) error {
+	f, err := os.Create(jl.path + ".swp")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, entry := range entries {
+		if err = enc.Encode(entry); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	// Windows cannot replace a file that is still open
+	if jl.f != nil {
+		jl.f.Close()
+		jl.f = nil
+	}
+	renameErr := os.Rename(jl.path+".swp", jl.path)
+
+	// keep appending to the old journal if it could not be replaced
+	jl.f, err = os.OpenFile(jl.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if renameErr != nil {
+		return renameErr
+	}
+	jl.appended = 0
+	return err
+}
+
+// compactWhileLocked drops the entries that have been superseded by later entries of the same job.
+// Job names sort by the time the jobs were started, which keeps the order of load.
+func (jl *journal) compactWhileLocked() error {
+	entries := make([]*journalEntry, 0, len(jl.jobs))
+	for _, entry := range jl.jobs {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Job < entries[j].Job
+	})
+	log.Debugf("Compacting journal %s to %d entries", jl.path, len(entries))
+	return jl.rewriteWhileLocked(entries)
+}
+
+func (jl *journal) close() error {
+	jl.m.Lock()
+	defer jl.m.Unlock()
+
+	if jl.f == nil {
+		return nil
+	}
+	err := jl.f.Close()
+	jl.f = nil
+	return err
+}
+
+func (jl *journal) append(entry *journalEntry) error {
+	jl.m.Lock()
+	defer jl.m.Unlock()
+
+	if jl.f == nil {
+		return fmt.Errorf("Cannot record state %s of job %s, journal is closed", entry.State, entry.Job)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err = jl.f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	jl.jobs[entry.Job] = entry
+	if err = jl.f.Sync(); err != nil {
+		return err
+	}
+	jl.appended++
+	if jl.appended >= journalCompactEntries && jl.appended > 2*len(jl.jobs) {
+		return jl.compactWhileLocked()
+	}
+	return nil
+}
+
+// latest returns the latest entry of every job recorded since the journal was opened.
+func (jl *journal) latest() []*journalEntry {
+	jl.m.Lock()
+	defer jl.m.Unlock()
+
+	entries := make([]*journalEntry, 0, len(jl.jobs))
+	for _, entry := range jl.jobs {
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// entry returns a copy of the latest entry of a job, or an entry with just its name if the job is unknown.
+func (jl *journal) entry(job string) *journalEntry {
+	jl.m.Lock()
+	defer jl.m.Unlock()
+
+	if entry, found := jl.jobs[job]; found {
+		e := *entry
+		return &e
+	}
+	return &journalEntry{Job: job}
+}
+
+// forget drops a finished job whose files have been removed, it disappears from the journal
+// file the next time the journal is opened.
+func (jl *journal) forget(job string) {
+	jl.m.Lock()
+	defer jl.m.Unlock()
+	delete(jl.jobs, job)
+}
+
+// record appends the new state of a job to the journal.
+func (jl *journal) record(j *Job, state JobState, jobErr error) {
+	j.update(state, jobErr)
+	if err := jl.append(j.journalEntry(state, jobErr)); err != nil {
+		log.Errorf("Could not update job journal: %s", err)
+	}
+}
+
+// recoverJobs looks for jobs that were interrupted by a crash or shutdown and turns them into
+// jobs that can be submitted again. It also rescues data left in the spool files of the queues
+// that never made it into a job. Must be called before the queues are started.
+func (m *Monitor) recoverJobs() ([]*Job, error) {
+	entries, err := m.journal.load()
+	if err != nil {
+		return nil, err
+	}
+
+	recovered := make([]*Job, 0)
+	retained := make([]*journalEntry, 0)
+	spooling := make(map[string]bool)
+
+	for _, entry := range entries {
+		if entry.State.Finished() {
+			// finished jobs are kept around until the retention policy removes their files
+			if _, err := os.Stat(entry.File); err == nil {
+				retained = append(retained, entry)
+			}
+			continue
+		}
+
+		job := m.jobFromEntry(entry)
+		job.Recovered = true
+		queue := job.queue
+
+		if entry.State == JobSpooling {
+			spooling[entry.Queue] = true
+		}
+		if queue == nil {
+			entry.State = JobFailed
+			entry.Error = fmt.Sprintf("queue %s no longer exists", entry.Queue)
+		} else if entry.State == JobSpooling {
+			if err := recoverSpoolFile(queue.File, job.File); err != nil {
+				entry.State = JobFailed
+				entry.Error = err.Error()
+			}
+		} else if _, err := os.Stat(job.File); err != nil {
+			entry.State = JobFailed
+			entry.Error = err.Error()
+		}
+
+		entry.Time = time.Now()
+		if entry.State == JobFailed {
+			log.Errorf("Could not recover job %s: %s", job.Name, entry.Error)
+			m.events.publish(Event{
+				Type:   JobDropped,
+				Device: entry.Device,
+				Queue:  entry.Queue,
+				Job:    entry.Job,
+				Err:    errors.New(entry.Error),
+			})
+			continue
+		}
+
+		log.Warnf("Recovered job %s (%s) for queue %s", job.Name, entry.State, entry.Queue)
+		job.submitted = true
+		job.update(JobSubmitted, nil)
+		retained = append(retained, job.journalEntry(JobSubmitted, nil))
+		recovered = append(recovered, job)
+	}
+
+	// Data in a spool file that is not covered by the journal was written while we were not running
+	for _, queue := range m.Queues() {
+		if spooling[filepath.Base(queue.File)] {
+			continue
+		}
+		if job := rescueSpoolFile(queue); job != nil {
+			job.update(JobSubmitted, nil)
+			retained = append(retained, job.journalEntry(JobSubmitted, nil))
+			recovered = append(recovered, job)
+		}
+	}
+
+	if err = m.journal.open(retained); err != nil {
+		return nil, err
+	}
+	return recovered, nil
+}
+
+// rescueSpoolFile turns data left in the spool file of a queue that has not been started yet into
+// a job. It returns nil if there is nothing to rescue.
+func rescueSpoolFile(queue *Queue) *Job {
+	queue.m.Lock()
+	job := queue.newJob(time.Now())
+	queue.m.Unlock()
+	job.Recovered = true
+	job.linked = true
+	job.submitted = true
+	if err := recoverSpoolFile(queue.File, job.File); err != nil {
+		if err != errEmptySpoolFile && !os.IsNotExist(err) {
+			log.Errorf("Could not recover spool file %s: %s", queue.File, err)
+		}
+		return nil
+	}
+	log.Warnf("Recovered unfinished spool file %s as job %s", queue.File, job.Name)
+	return job
+}
+
+// jobFromEntry recreates a job from its journal entry.
+func (m *Monitor) jobFromEntry(entry *journalEntry) *Job {
+	job := &Job{
+		Time:      entry.Started,
+		Name:      entry.Job,
+		Device:    entry.Device,
+		File:      entry.File,
+		queueFile: entry.Queue,
+		Printer:   entry.Printer,
+		User:      entry.User,
+		Host:      entry.Host,
+		Title:     entry.Title,
+		submitted: entry.State != JobSpooling,
+		linked:    true,
+		held:      entry.Held,
+		duplicate: entry.Held,
+		monitor:   m,
+		record:    entry.record(),
+	}
+	if queue := m.queueForFile(entry.Queue); queue != nil {
+		job.queue = queue
+		job.Printer = queue.Settings.Get("printer")
+	}
+	return job
+}
+
+// recoverSpoolFile links a non-empty spool file to the file of a job.
+func recoverSpoolFile(spoolFile string, jobFile string) error {
+	if _, err := os.Stat(jobFile); err == nil {
+		// we crashed after linking the job file
+		return nil
+	}
+	fi, err := os.Stat(spoolFile)
+	if err != nil {
+		return err
+	}
+	if fi.Size() == 0 {
+		return errEmptySpoolFile
+	}
+	return os.Link(spoolFile, jobFile)
+}
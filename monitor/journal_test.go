@@ -0,0 +1,187 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func entryStates(entries []*journalEntry) string {
+	states := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		states = append(states, fmt.Sprintf("%s %s", entry.Job, entry.State))
+	}
+	return strings.Join(states, ", ")
+}
+
+func TestJournalRoundTrip(t *testing.T) {
+	jl := newJournal(t.TempDir())
+	if err := jl.open(nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []*journalEntry{
+		{Job: "a", State: JobSpooling},
+		{Job: "b", State: JobSpooling},
+		{Job: "a", State: JobSubmitted},
+		{Job: "a", State: JobDone},
+	} {
+		if err := jl.append(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := jl.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a crash can leave a partial line at the end
+	f, err := os.OpenFile(jl.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"job":"b","sta`)
+	f.Close()
+
+	entries, err := jl.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := entryStates(entries), "a done, b spooling"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestJournalCompaction(t *testing.T) {
+	jl := newJournal(t.TempDir())
+	if err := jl.open(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer jl.close()
+
+	states := []JobState{JobSpooling, JobSubmitted, JobProcessing}
+	for i := 0; i < journalCompactEntries; i++ {
+		entry := &journalEntry{Job: fmt.Sprintf("pj-%d", i%2), State: states[i/2%len(states)]}
+		if err := jl.append(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := jl.append(&journalEntry{Job: "pj-2", State: JobSpooling}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(jl.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines != 3 {
+		t.Errorf("journal has %d lines after compaction, want 3", lines)
+	}
+	entries, err := jl.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := states[(journalCompactEntries-1)/2%len(states)]
+	want := fmt.Sprintf("pj-0 %s, pj-1 %s, pj-2 spooling", last, last)
+	if got := entryStates(entries); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRecoverJobs(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	m := NewMonitor(dir, nil)
+	for _, file := range []string{"one.txt", "two.txt"} {
+		if _, err := m.AddTCPPort(strings.ToUpper(file[:3]), file, "127.0.0.1:0", file[:3]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events := m.Subscribe()
+	defer events.Cancel()
+
+	write("one.txt", "spooled")
+	write("two.txt", "rescued")
+	journal := ""
+	for _, entry := range []*journalEntry{
+		{Job: "job-1", Queue: "one.txt", File: filepath.Join(dir, "job-1.prn"), State: JobSpooling},
+		{Job: "job-2", Queue: "one.txt", File: write("job-2.prn", "submitted"), State: JobSubmitted},
+		{Job: "job-3", Queue: "one.txt", File: filepath.Join(dir, "job-3.prn"), State: JobProcessing},
+		{Job: "job-4", Queue: "gone.txt", File: filepath.Join(dir, "job-4.prn"), State: JobSpooling},
+		{Job: "job-5", Queue: "gone.txt", File: write("job-5.prn", "orphaned"), State: JobSubmitted},
+		{Job: "job-6", Queue: "one.txt", File: write("job-6.prn", "printed"), State: JobDone},
+	} {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		journal += string(data) + "\n"
+	}
+	write(journalFile, journal)
+
+	jobs, err := m.recoverJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.journal.close()
+
+	got := make([]string, 0, len(jobs))
+	for _, j := range jobs {
+		if !j.Recovered || !j.submitted || j.queue == nil {
+			t.Errorf("job %s: recovered %v, submitted %v, queue %v", j.Name, j.Recovered, j.submitted, j.queue)
+		}
+		got = append(got, fmt.Sprintf("%s %s", j.queueFile, jobData(t, j)))
+	}
+	if want := "one.txt spooled, one.txt submitted, two.txt rescued"; strings.Join(got, ", ") != want {
+		t.Errorf("got jobs %q, want %q", strings.Join(got, ", "), want)
+	}
+
+	dropped := make([]string, 0)
+	for i := 0; i < 3; i++ {
+		dropped = append(dropped, nextEvent(t, events, JobDropped).Job)
+	}
+	if want := "job-3 job-4 job-5"; strings.Join(dropped, " ") != want {
+		t.Errorf("got dropped jobs %q, want %q", strings.Join(dropped, " "), want)
+	}
+
+	states := make([]string, 0)
+	for _, entry := range m.journal.latest() {
+		if strings.HasPrefix(entry.Job, "job-") {
+			states = append(states, fmt.Sprintf("%s %s", entry.Job, entry.State))
+		}
+	}
+	sort.Strings(states)
+	if want := "job-1 submitted, job-2 submitted, job-6 done"; strings.Join(states, ", ") != want {
+		t.Errorf("got journal %q, want %q", strings.Join(states, ", "), want)
+	}
+}
+
+func TestRescueSpoolFileOfAddedQueue(t *testing.T) {
+	dir := t.TempDir()
+	m := NewMonitor(dir, nil)
+	runMonitor(t, m)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "net.txt"), []byte("leftover"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.AddTCPPort("NET", "net.txt", "127.0.0.1:0", "net"); err != nil {
+		t.Fatal(err)
+	}
+
+	j := nextJob(t, m)
+	if got := jobData(t, j); got != "leftover" || !j.Recovered {
+		t.Errorf("got job %q, recovered %v", got, j.Recovered)
+	}
+	j.Done()
+}
@@ -1,37 +1,55 @@
 package monitor
 
 import (
+	"context"
+	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/rjeczalik/notify"
+	log "github.com/sirupsen/logrus"
 )
 
 type JobChannel <-chan *Job
 type JobValidationFunc func(*os.File) bool
 
 type Monitor struct {
-	active   int64 // This has to be first to guarantee alignment for the atomic updates
-	m        sync.Mutex
-	path     string
-	state    state
-	spooling chan int
-	fsEvents chan notify.EventInfo
-	queues   map[string]*Queue
-	jobs     chan *Job
-	isValid  JobValidationFunc
+	active    int64 // This has to be first to guarantee alignment for the atomic updates
+	jobSeq    uint32
+	m         sync.Mutex
+	path      string
+	state     State
+	spooling  chan int
+	writes    chan string
+	watcher   Watcher
+	queues    map[string]*Queue
+	jobs      chan *Job
+	isValid   JobValidationFunc
+	journal   *journal
+	work      *workQueue
+	events    *eventBroker
+	retention RetentionPolicy
 }
 
 func NewMonitor(path string, isValid JobValidationFunc) *Monitor {
-	return &Monitor{
+	m := &Monitor{
 		path:     path,
-		state:    valid,
-		fsEvents: make(chan notify.EventInfo, 10),
+		state:    StateValid,
+		writes:   make(chan string, 10),
 		spooling: make(chan int, 1),
 		queues:   make(map[string]*Queue),
-		jobs:     make(chan *Job, 1),
+		jobs:     make(chan *Job),
 		isValid:  isValid,
+		journal:  newJournal(path),
+		events:   newEventBroker(),
+		jobSeq:   uint32(time.Now().UnixNano() / int64(time.Millisecond)),
 	}
+	m.work = newWorkQueue(m)
+	return m
 }
 
 func (m *Monitor) Path() string {
@@ -45,3 +63,350 @@ func (m *Monitor) Jobs() JobChannel {
 func (m *Monitor) Spooling() <-chan int {
 	return m.spooling
 }
+
+// SpoolingJobs returns the number of jobs that are currently spooling. It is up to date by the
+// time the events about starting, completing or dropping a job are published.
+func (m *Monitor) SpoolingJobs() int {
+	return int(atomic.LoadInt64(&m.active))
+}
+
+func (m *Monitor) updateSpooling(delta int) {
+	new := int(atomic.AddInt64(&(m.active), int64(delta)))
+	select {
+	case m.spooling <- new:
+	default:
+	}
+}
+
+// AddDevice adds a local device. The port selects how the device is created, network and serial
+// devices have their own functions.
+func (m *Monitor) AddDevice(device string, file string, name string, timeout time.Duration, port PortType) (queue *Queue, err error) {
+
+	if file != filepath.Base(file) {
+		return nil, fmt.Errorf("filename must not contain path components: %s", file)
+	}
+
+	if port == PortTCP || port == PortSerial {
+		return nil, fmt.Errorf("Cannot add device %s with port type %s as a local device", device, port)
+	}
+
+	return m.addQueue(file, &Queue{
+		Device:   device,
+		File:     filepath.Join(m.path, file),
+		name:     name,
+		Port:     port,
+		Settings: &dummySettings{},
+		state:    StateValid,
+		monitor:  m,
+		timeout:  timeout,
+		detector: IdleTimeout(),
+	})
+}
+
+func (m *Monitor) AddLPTPort(port int, name string) (queue *Queue, err error) {
+
+	device := fmt.Sprintf("LPT%d", port)
+
+	if port < 1 || port > 9 {
+		err = fmt.Errorf("Invalid device %s, only support LPT1 - LPT9", device)
+		return
+	}
+
+	file := fmt.Sprintf("lpt-%d.txt", port)
+	return m.addQueue(file, &Queue{
+		Device:   device,
+		File:     filepath.Join(m.path, file),
+		name:     name,
+		Settings: &dummySettings{},
+		state:    StateValid,
+		monitor:  m,
+		timeout:  1000 * time.Millisecond,
+		detector: IdleTimeout(),
+	})
+}
+
+// AddTCPPort adds a network device that accepts raw print data on address, like a printer on port 9100.
+func (m *Monitor) AddTCPPort(device string, file string, address string, name string) (queue *Queue, err error) {
+
+	if file != filepath.Base(file) {
+		return nil, fmt.Errorf("filename must not contain path components: %s", file)
+	}
+
+	if _, _, err = net.SplitHostPort(address); err != nil {
+		return nil, fmt.Errorf("Invalid address for device %s: %s", device, err)
+	}
+
+	return m.addQueue(file, &Queue{
+		Device:   device,
+		File:     filepath.Join(m.path, file),
+		name:     name,
+		Port:     PortTCP,
+		Address:  address,
+		Settings: &dummySettings{},
+		state:    StateValid,
+		monitor:  m,
+		detector: IdleTimeout(),
+	})
+}
+
+// addQueue registers a new queue. If the monitor is already running, the queue is started right away.
+func (m *Monitor) addQueue(file string, queue *Queue) (*Queue, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	if _, found := m.queues[file]; found {
+		return nil, fmt.Errorf("Cannot add %s, already monitoring", queue.Device)
+	}
+	for _, q := range m.queues {
+		if strings.EqualFold(q.Device, queue.Device) {
+			return nil, fmt.Errorf("Cannot add %s, already monitoring", queue.Device)
+		}
+	}
+
+	if m.state == StateRunning {
+		// starting the queue truncates its spool file
+		if job := rescueSpoolFile(queue); job != nil {
+			m.journal.record(job, JobSubmitted, nil)
+			if err := m.work.put(job, true); err != nil {
+				return nil, err
+			}
+		}
+		log.Infof("Starting queue %s", file)
+		if err := queue.start(); err != nil {
+			return nil, queue.publishError(err)
+		}
+	}
+
+	m.queues[file] = queue
+	return queue, nil
+}
+
+// Queue returns the queue for a device or nil if the device is not monitored.
+func (m *Monitor) Queue(device string) *Queue {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	for _, q := range m.queues {
+		if strings.EqualFold(q.Device, device) {
+			return q
+		}
+	}
+	return nil
+}
+
+// Queues returns all queues of the monitor.
+func (m *Monitor) Queues() []*Queue {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	queues := make([]*Queue, 0, len(m.queues))
+	for _, q := range m.queues {
+		queues = append(queues, q)
+	}
+	return queues
+}
+
+func (m *Monitor) queueForFile(file string) *Queue {
+	m.m.Lock()
+	defer m.m.Unlock()
+	return m.queues[file]
+}
+
+// RemoveDevice stops monitoring a device. If the queue is currently spooling a job, it waits for the
+// job to complete. Once ctx is done, whatever has been captured so far is submitted as a job.
+func (m *Monitor) RemoveDevice(ctx context.Context, device string) error {
+	queue := m.Queue(device)
+	if queue == nil {
+		return fmt.Errorf("Cannot remove %s, not monitoring", device)
+	}
+
+	log.Infof("Removing queue %s for device %s", queue.Name(), device)
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+drain:
+	for queue.IsSpooling() {
+		select {
+		case <-ctx.Done():
+			log.Warnf("Timeout while draining queue %s, submitting incomplete job", queue.Name())
+			if err := queue.flush(); err != nil {
+				log.Error(err)
+			}
+			break drain
+		case <-ticker.C:
+		}
+	}
+
+	m.m.Lock()
+	defer m.m.Unlock()
+	delete(m.queues, filepath.Base(queue.File))
+	queue.stop()
+	return nil
+}
+
+// Start TODO
+func (m *Monitor) Start(ctx context.Context) error {
+
+	m.m.Lock()
+	if err := m.state.transition(StateStarting); err != nil {
+		m.m.Unlock()
+		return fmt.Errorf("Cannot start monitor with state %s", m.state)
+	}
+	m.m.Unlock()
+
+	defer func() {
+		m.m.Lock()
+		defer m.m.Unlock()
+		if m.state != StateStopped {
+			m.state.transition(StateInvalid)
+		}
+	}()
+
+	defer close(m.jobs)
+	defer close(m.spooling)
+	defer m.events.close()
+
+	log.Infof("Starting monitor in directory %s", m.path)
+
+	err := os.MkdirAll(m.path, 0755)
+	if err != nil {
+		return err
+	}
+
+	// This has to happen before starting the queues, which truncates the spool files
+	recovered, err := m.recoverJobs()
+	if err != nil {
+		return err
+	}
+	defer m.journal.close()
+
+	if err = m.work.clearSpilled(); err != nil {
+		return err
+	}
+	for _, job := range recovered {
+		// there is no consumer yet, so we must not block
+		if err = m.work.put(job, true); err != nil {
+			return err
+		}
+	}
+
+	dispatchCtx, stopDispatch := context.WithCancel(ctx)
+	dispatched := make(chan struct{})
+	go func() {
+		defer close(dispatched)
+		m.work.run(dispatchCtx, m.jobs)
+	}()
+	// must run before closing the jobs channel
+	defer func() {
+		m.work.close()
+		stopDispatch()
+		<-dispatched
+	}()
+
+	defer m.stopQueues()
+	if err = m.startQueues(); err != nil {
+		return err
+	}
+
+	watcher, err := m.watch(m.writes)
+	if err != nil {
+		return err
+	}
+	defer watcher.Stop()
+
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	m.m.Lock()
+	retention := m.retention
+	m.m.Unlock()
+	var gc <-chan time.Time
+	if retention.enabled() {
+		interval := retention.Interval
+		if interval <= 0 {
+			interval = DefaultRetentionInterval
+		}
+		gcTicker := time.NewTicker(interval)
+		defer gcTicker.Stop()
+		gc = gcTicker.C
+		if err = m.collectGarbage(retention, time.Now()); err != nil {
+			log.Errorf("Could not apply retention policy: %s", err)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			m.m.Lock()
+			m.state.transition(StateStopped)
+			m.m.Unlock()
+			return nil
+		case path := <-m.writes:
+			file := filepath.Base(path)
+			if queue := m.queueForFile(file); queue != nil {
+				log.Debugf("Write to %s for queue %s", path, queue.Name())
+				if !queue.IsSpooling() {
+					if fi, err := os.Stat(queue.File); err != nil {
+						log.Error(err)
+						break
+					} else {
+						if fi.Size() == 0 {
+							// spurious write event from creation of spool file
+							break
+						}
+					}
+					log.Infof("Started new job for queue %s", queue.Name())
+					queue.startJob()
+					queue.recordActivity(time.Now(), true)
+				} else {
+					queue.recordActivity(time.Now(), false)
+					queue.progress()
+				}
+			}
+		case now := <-gc:
+			if err := m.collectGarbage(retention, now); err != nil {
+				log.Errorf("Could not apply retention policy: %s", err)
+			}
+		case <-ticker.C:
+			for _, queue := range m.Queues() {
+				if !queue.IsSpooling() {
+					continue
+				}
+				complete, err := queue.jobComplete()
+				if err != nil {
+					log.Error(queue.publishError(err))
+					continue
+				}
+				if complete {
+					log.Infof("Job complete for queue %s", queue.Name())
+					queue.submitJob()
+				}
+			}
+		}
+	}
+}
+
+// startQueues starts all queues and switches the monitor to running, queues added after this
+// point are started immediately.
+func (m *Monitor) startQueues() error {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	for file, queue := range m.queues {
+		log.Infof("Starting queue %s", file)
+		if err := queue.start(); err != nil {
+			return queue.publishError(err)
+		}
+	}
+	return m.state.transition(StateRunning)
+}
+
+func (m *Monitor) stopQueues() {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	for _, queue := range m.queues {
+		queue.stop()
+	}
+}
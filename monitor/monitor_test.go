@@ -0,0 +1,77 @@
+package monitor
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// runMonitor starts the monitor and stops it once the test is over.
+func runMonitor(t *testing.T, m *Monitor) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Start(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Monitor failed: %s", err)
+		}
+	})
+	waitFor(t, "monitor to start", func() bool {
+		return m.Status().State == StateRunning
+	})
+}
+
+// waitFor polls condition until it holds or the test times out.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// nextJob waits for the next job handed to the consumer.
+func nextJob(t *testing.T, m *Monitor) *Job {
+	t.Helper()
+	select {
+	case j := <-m.Jobs():
+		return j
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for a job")
+		return nil
+	}
+}
+
+// nextEvent waits for the next event of the given type.
+func nextEvent(t *testing.T, s *Subscription, typ EventType) Event {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-s.Events():
+			if e.Type == typ {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("Timeout waiting for event %s", typ)
+			return Event{}
+		}
+	}
+}
+
+func jobData(t *testing.T, j *Job) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(j.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
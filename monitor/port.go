@@ -0,0 +1,94 @@
+package monitor
+
+import (
+	"fmt"
+	"io"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PortType selects how the device of a queue is exposed to the printing application.
+type PortType int
+
+const (
+	// PortDefault uses the native mechanism of the platform (DOS devices on Windows, FIFOs on Linux)
+	PortDefault PortType = iota
+	PortFIFO
+	PortPTY
+	// PortTCP listens on the address of the queue, every connection is one job
+	PortTCP
+	// PortSerial captures the data arriving on a serial port
+	PortSerial
+)
+
+func (p PortType) String() string {
+	switch p {
+	case PortDefault:
+		return "default"
+	case PortFIFO:
+		return "fifo"
+	case PortPTY:
+		return "pty"
+	case PortTCP:
+		return "tcp"
+	case PortSerial:
+		return "serial"
+	default:
+		return fmt.Sprintf("UNKNOWN PORT TYPE: %d", p)
+	}
+}
+
+func ParsePortType(s string) (PortType, error) {
+	switch s {
+	case "", "default":
+		return PortDefault, nil
+	case "fifo":
+		return PortFIFO, nil
+	case "pty":
+		return PortPTY, nil
+	case "tcp":
+		return PortTCP, nil
+	case "serial":
+		return PortSerial, nil
+	default:
+		return PortDefault, fmt.Errorf("Unknown port type: %s", s)
+	}
+}
+
+// portBinder connects the device of a queue to its spool file. Binders either
+// redirect the device to the spool file directly or capture the data written
+// to the device and append it to the spool file.
+type portBinder interface {
+	bind(q *Queue) error
+	unbind(q *Queue) error
+}
+
+// delimitsJobs reports whether the port itself knows where a job ends, which makes the
+// completion detector of the queue unnecessary.
+func (p PortType) delimitsJobs() bool {
+	return p == PortTCP
+}
+
+func newPortBinder(port PortType) (portBinder, error) {
+	if port == PortTCP {
+		return &tcpBinder{}, nil
+	}
+	return newDeviceBinder(port)
+}
+
+// pump copies everything read from r into the spool file of q until r is closed.
+func pump(q *Queue, r io.Reader) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if err := q.appendSpool(buf[:n]); err != nil {
+				log.Errorf("Could not write to spool file %s: %s", q.File, err)
+			}
+		}
+		if err != nil {
+			log.Debugf("Stopped capturing device %s: %s", q.Device, err)
+			return
+		}
+	}
+}
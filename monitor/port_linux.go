@@ -0,0 +1,187 @@
+// +build linux
+
+package monitor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+func newDeviceBinder(port PortType) (portBinder, error) {
+	switch port {
+	case PortDefault, PortFIFO:
+		return &fifoBinder{}, nil
+	case PortPTY:
+		return &ptyBinder{}, nil
+	case PortSerial:
+		return &serialBinder{}, nil
+	default:
+		return nil, fmt.Errorf("Port type %s is not supported on Linux", port)
+	}
+}
+
+// devicePath returns the file system node that the printing application writes to.
+// Absolute device names are used as is, all others are placed next to the spool file,
+// so LPT1 turns into <spool dir>/lpt1.
+func devicePath(q *Queue) string {
+	if filepath.IsAbs(q.Device) {
+		return q.Device
+	}
+	return filepath.Join(filepath.Dir(q.File), strings.ToLower(q.Device))
+}
+
+// removeNode removes a stale device node left over from a previous run.
+func removeNode(path string) error {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// fifoBinder exposes the device as a named pipe and copies everything written
+// to it into the spool file.
+type fifoBinder struct {
+	f    *os.File
+	done chan struct{}
+}
+
+func (b *fifoBinder) bind(q *Queue) error {
+	path := devicePath(q)
+	if err := removeNode(path); err != nil {
+		return err
+	}
+	if err := unix.Mkfifo(path, 0666); err != nil {
+		return err
+	}
+
+	// Opening the FIFO for reading and writing keeps it from returning EOF whenever
+	// a writer closes it and makes sure that the open call does not block.
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	b.f = f
+	b.done = make(chan struct{})
+	go func() {
+		defer close(b.done)
+		pump(q, f)
+	}()
+
+	log.Infof("Capturing %s from FIFO %s", q.Device, path)
+	return nil
+}
+
+func (b *fifoBinder) unbind(q *Queue) error {
+	err := b.f.Close()
+	<-b.done
+	if rerr := removeNode(devicePath(q)); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// ptyBinder exposes the device as the slave side of a pseudo terminal for
+// applications that insist on talking to a terminal. The device path is a
+// symlink to the slave.
+type ptyBinder struct {
+	master *os.File
+	slave  *os.File
+	done   chan struct{}
+}
+
+func (b *ptyBinder) bind(q *Queue) (err error) {
+	path := devicePath(q)
+	if err = removeNode(path); err != nil {
+		return err
+	}
+
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			master.Close()
+		}
+	}()
+
+	// Calling Fd() would switch the master to blocking mode, and we could no longer
+	// interrupt the pending read when unbinding
+	rc, err := master.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var n int
+	cerr := rc.Control(func(fd uintptr) {
+		if err = unix.IoctlSetPointerInt(int(fd), unix.TIOCSPTLCK, 0); err != nil {
+			return
+		}
+		n, err = unix.IoctlGetInt(int(fd), unix.TIOCGPTN)
+	})
+	if err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	slaveName := fmt.Sprintf("/dev/pts/%d", n)
+
+	// We keep the slave open ourselves, otherwise reading from the master fails
+	// with EIO while the application has not opened the device
+	slave, err := os.OpenFile(slaveName, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			slave.Close()
+		}
+	}()
+
+	// Switch the terminal to raw mode, we do not want any translation of the print data
+	termios, err := unix.IoctlGetTermios(int(slave.Fd()), unix.TCGETS)
+	if err != nil {
+		return err
+	}
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	termios.Oflag &^= unix.OPOST
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Cflag &^= unix.CSIZE | unix.PARENB
+	termios.Cflag |= unix.CS8
+	if err = unix.IoctlSetTermios(int(slave.Fd()), unix.TCSETS, termios); err != nil {
+		return err
+	}
+
+	if err = os.Symlink(slaveName, path); err != nil {
+		return err
+	}
+
+	b.master = master
+	b.slave = slave
+	b.done = make(chan struct{})
+	go func() {
+		defer close(b.done)
+		pump(q, master)
+	}()
+
+	log.Infof("Capturing %s from pseudo terminal %s (%s)", q.Device, path, slaveName)
+	return nil
+}
+
+func (b *ptyBinder) unbind(q *Queue) error {
+	err := b.master.Close()
+	<-b.done
+	b.slave.Close()
+	if rerr := removeNode(devicePath(q)); err == nil {
+		err = rerr
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+// +build linux
+
+package monitor
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// writeDevice writes a job to the device of a queue like a printing application would.
+func writeDevice(t *testing.T, q *Queue, data string) {
+	t.Helper()
+	f, err := os.OpenFile(devicePath(q), os.O_WRONLY|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDevicePorts(t *testing.T) {
+	tests := []struct {
+		port PortType
+		mode os.FileMode
+	}{
+		{PortDefault, os.ModeNamedPipe},
+		{PortFIFO, os.ModeNamedPipe},
+		{PortPTY, os.ModeSymlink},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.port.String(), func(t *testing.T) {
+			m := NewMonitor(t.TempDir(), nil)
+			q, err := m.AddDevice("LPT1", "lpt1.txt", "lpt1", 200*time.Millisecond, tt.port)
+			if err != nil {
+				t.Fatal(err)
+			}
+			runMonitor(t, m)
+
+			fi, err := os.Lstat(devicePath(q))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode()&os.ModeType != tt.mode {
+				t.Errorf("device is %s, want %s", fi.Mode()&os.ModeType, tt.mode)
+			}
+
+			// the data must arrive untouched, terminals would translate line endings
+			for _, data := range []string{"\x1bEfirst\r\n\x1bE", "second\n\x00\x03\x1a"} {
+				writeDevice(t, q, data)
+				j := nextJob(t, m)
+				if got := jobData(t, j); got != data {
+					t.Errorf("got job %q, want %q", got, data)
+				}
+				j.Done()
+			}
+
+			if err = m.RemoveDevice(context.Background(), "LPT1"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = os.Lstat(devicePath(q)); !os.IsNotExist(err) {
+				t.Errorf("device has not been removed: %v", err)
+			}
+		})
+	}
+}
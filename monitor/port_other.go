@@ -0,0 +1,12 @@
+// +build !windows,!linux
+
+package monitor
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func newDeviceBinder(port PortType) (portBinder, error) {
+	return nil, fmt.Errorf("Capturing devices is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,131 @@
+package monitor
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// tcpBinder accepts raw print data on a TCP port like a JetDirect printer on port 9100. Every
+// connection is one job, which is complete once the client closes the connection. Connections
+// are handled one at a time, further clients wait in the backlog of the listener.
+type tcpBinder struct {
+	m        sync.Mutex
+	listener net.Listener
+	conn     net.Conn
+	closed   bool
+}
+
+func (b *tcpBinder) bind(q *Queue) error {
+	listener, err := net.Listen("tcp", q.Address)
+	if err != nil {
+		return err
+	}
+	b.listener = listener
+	log.Infof("Accepting print jobs for %s on %s", q.Device, listener.Addr())
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if !b.isClosed() {
+					log.Errorf("Could not accept connection for %s: %s", q.Device, q.publishError(err))
+				}
+				return
+			}
+			b.receive(q, conn)
+		}
+	}()
+	return nil
+}
+
+func (b *tcpBinder) isClosed() bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.closed
+}
+
+func (b *tcpBinder) receive(q *Queue, conn net.Conn) {
+	b.m.Lock()
+	if b.closed {
+		b.m.Unlock()
+		conn.Close()
+		return
+	}
+	b.conn = conn
+	b.m.Unlock()
+
+	defer func() {
+		b.m.Lock()
+		b.conn = nil
+		b.m.Unlock()
+		conn.Close()
+	}()
+
+	log.Debugf("Connection from %s to %s", conn.RemoteAddr(), q.Device)
+
+	// clients that stop sending would block the port for everyone else, the job ends once they
+	// have been idle for the timeout of the queue
+	r := idleConn{Conn: conn, q: q}
+
+	// clients probe the port without sending anything, that must not create a job
+	buf := make([]byte, 32*1024)
+	n, err := r.Read(buf)
+	if n == 0 {
+		if err != nil && err != io.EOF {
+			log.Debugf("Connection from %s to %s failed: %s", conn.RemoteAddr(), q.Device, err)
+		}
+		return
+	}
+
+	job, err := q.startJob()
+	if err != nil {
+		log.Error(q.publishError(err))
+		return
+	}
+	log.Infof("Started new job for queue %s from %s", q.Name(), conn.RemoteAddr())
+	q.recordActivity(time.Now(), true)
+	if err = q.appendSpool(buf[:n]); err != nil {
+		log.Errorf("Could not write to spool file %s: %s", q.File, err)
+	}
+	pump(q, r)
+
+	if b.isClosed() {
+		// the queue is shutting down, the job is recovered on the next start
+		return
+	}
+	log.Infof("Job complete for queue %s", q.Name())
+	// the validator holds back jobs whose data might still be arriving, which cannot happen once
+	// the connection is gone, and a held back job would never be validated again, as the ticker
+	// of the monitor leaves ports that delimit jobs alone
+	if err = job.trySubmit(false); err != nil {
+		q.submitFailed(job, err)
+	}
+}
+
+// idleConn moves the read deadline of the connection forward on every read. Queues without a
+// timeout wait for the client to close the connection.
+type idleConn struct {
+	net.Conn
+	q *Queue
+}
+
+func (c idleConn) Read(p []byte) (int, error) {
+	if timeout := c.q.Timeout(); timeout > 0 {
+		c.SetReadDeadline(time.Now().Add(timeout))
+	}
+	return c.Conn.Read(p)
+}
+
+func (b *tcpBinder) unbind(q *Queue) error {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.closed = true
+	if b.conn != nil {
+		b.conn.Close()
+	}
+	return b.listener.Close()
+}
@@ -0,0 +1,141 @@
+package monitor
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func startTCPQueue(t *testing.T) (*Monitor, *Queue, string) {
+	t.Helper()
+	m := NewMonitor(t.TempDir(), nil)
+	q, err := m.AddTCPPort("NET", "net.txt", "127.0.0.1:0", "net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.SetTimeout(time.Second)
+	runMonitor(t, m)
+	q.m.Lock()
+	address := q.binder.(*tcpBinder).listener.Addr().String()
+	q.m.Unlock()
+	return m, q, address
+}
+
+func sendTCP(t *testing.T, address string, data string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestTCPPort(t *testing.T) {
+	m, _, address := startTCPQueue(t)
+
+	for _, data := range []string{"first job", "second job"} {
+		sendTCP(t, address, data).Close()
+		j := nextJob(t, m)
+		if got := jobData(t, j); got != data {
+			t.Errorf("got job %q, want %q", got, data)
+		}
+		j.Done()
+	}
+}
+
+func TestTCPPortProbe(t *testing.T) {
+	m, q, address := startTCPQueue(t)
+
+	// connections without data do not create jobs
+	probe, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe.Close()
+
+	// clients that never send anything are dropped after the timeout
+	silent, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	sendTCP(t, address, "data").Close()
+	j := nextJob(t, m)
+	if got := jobData(t, j); got != "data" {
+		t.Errorf("got job %q", got)
+	}
+	j.Done()
+	if q.IsSpooling() {
+		t.Errorf("queue is still spooling")
+	}
+}
+
+func TestTCPPortSubmitFailed(t *testing.T) {
+	m, q, address := startTCPQueue(t)
+	events := m.Subscribe()
+	defer events.Cancel()
+
+	conn := sendTCP(t, address, "lost")
+	waitFor(t, "data to arrive", func() bool {
+		return q.Status().Bytes > 0
+	})
+	// the job file cannot be linked without the spool file
+	q.m.Lock()
+	os.Remove(q.File)
+	q.m.Unlock()
+	conn.Close()
+
+	if e := nextEvent(t, events, JobDropped); e.Err == nil {
+		t.Errorf("dropped job without error")
+	}
+	if q.IsSpooling() {
+		t.Errorf("failed job is still attached to the queue")
+	}
+
+	sendTCP(t, address, "next").Close()
+	j := nextJob(t, m)
+	if got := jobData(t, j); got != "next" {
+		t.Errorf("got job %q, want next", got)
+	}
+	j.Done()
+}
+
+func TestTCPPortOneClientAtATime(t *testing.T) {
+	m, _, address := startTCPQueue(t)
+
+	// the second client waits in the backlog until the first one is done
+	first := sendTCP(t, address, "first")
+	sendTCP(t, address, "second").Close()
+	select {
+	case j := <-m.Jobs():
+		t.Fatalf("got job %q while the first client is still connected", jobData(t, j))
+	case <-time.After(300 * time.Millisecond):
+	}
+	first.Close()
+
+	for _, want := range []string{"first", "second"} {
+		j := nextJob(t, m)
+		if got := jobData(t, j); got != want {
+			t.Errorf("got job %q, want %q", got, want)
+		}
+		j.Done()
+	}
+}
+
+func TestTCPPortRemoved(t *testing.T) {
+	m, _, address := startTCPQueue(t)
+
+	if err := m.RemoveDevice(context.Background(), "NET"); err != nil {
+		t.Fatal(err)
+	}
+	if conn, err := net.Dial("tcp", address); err == nil {
+		conn.Close()
+		t.Errorf("removed port still accepts connections")
+	}
+}
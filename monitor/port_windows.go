@@ -0,0 +1,33 @@
+// +build windows
+
+package monitor
+
+import (
+	"fmt"
+)
+
+func newDeviceBinder(port PortType) (portBinder, error) {
+	switch port {
+	case PortDefault:
+		return &dosDeviceBinder{}, nil
+	case PortSerial:
+		return &serialBinder{}, nil
+	default:
+		return nil, fmt.Errorf("Port type %s is not supported on Windows", port)
+	}
+}
+
+// dosDeviceBinder redirects a DOS device like LPT1 to the spool file of the queue.
+type dosDeviceBinder struct{}
+
+func (b *dosDeviceBinder) devicePath(q *Queue) string {
+	return `\??\` + q.File
+}
+
+func (b *dosDeviceBinder) bind(q *Queue) error {
+	return DefineDosDevice(q.Device, b.devicePath(q), false, false, false)
+}
+
+func (b *dosDeviceBinder) unbind(q *Queue) error {
+	return DefineDosDevice(q.Device, b.devicePath(q), false, true, true)
+}
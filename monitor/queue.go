@@ -2,28 +2,38 @@ package monitor
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/smuething/devicemonitor/app"
 )
 
-type state int
+// State is the lifecycle state of queues and the monitor, see stateTransitions for the valid changes.
+type State int
 
 const (
-	invalid = iota
-	valid
-	running
-	stopped
+	StateInvalid State = iota
+	StateValid
+	StateStarting
+	StateRunning
+	StateStopped
 )
 
-func (s state) String() string {
+func (s State) String() string {
 	switch s {
-	case invalid:
+	case StateInvalid:
 		return "invalid"
-	case valid:
+	case StateValid:
 		return "valid"
-	case running:
+	case StateStarting:
+		return "starting"
+	case StateRunning:
 		return "running"
-	case stopped:
+	case StateStopped:
 		return "stopped"
 	default:
 		return fmt.Sprintf("UNKNOWN STATE: %d", s)
@@ -35,15 +45,398 @@ type Settings interface {
 	Set(name string, value string)
 }
 
+type dummySettings struct{}
+
+func (d *dummySettings) Get(string) string {
+	return ""
+}
+
+func (d *dummySettings) Set(string, string) {}
+
 type Queue struct {
 	m            sync.Mutex
 	Device       string
 	File         string
-	Name         string
+	name         string // display name, can change while the queue is running
+	Port         PortType
+	Address      string // listen address of network ports
+	Serial       SerialSettings
 	Settings     Settings
-	state        state
+	state        State
 	job          *Job
+	submitting   bool // a submit attempt of the job is in flight
 	lastActivity time.Time
 	timeout      time.Duration
-	monitor      *Monitor
+	// see status.go
+	lastError     string
+	lastErrorTime time.Time
+	// see timeout.go
+	extendTimeout   bool
+	extendedTimeout time.Duration
+	adaptiveTimeout bool
+	gaps            gapTracker
+	detector        JobCompletionDetector
+	validator       JobValidationFunc
+	// see duplicates.go
+	duplicates DuplicatePolicy
+	seen       []seenJob
+	monitor    *Monitor
+	binder     portBinder
+}
+
+func (q *Queue) IsSpooling() bool {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return q.job != nil
+}
+
+// Name returns the display name of the queue.
+func (q *Queue) Name() string {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return q.name
+}
+
+// SetName changes the display name of the queue.
+func (q *Queue) SetName(name string) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.name = name
+}
+
+// SetTimeout changes the idle timeout of the queue, it applies to the job that is currently spooling as well.
+func (q *Queue) SetTimeout(timeout time.Duration) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.timeout = timeout
+}
+
+// SetCompletionDetector replaces the strategy used to decide when a job is complete.
+func (q *Queue) SetCompletionDetector(detector JobCompletionDetector) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.detector = detector
+}
+
+// SetValidator sets the function that decides whether a completed job may be submitted. Jobs that
+// are rejected are held back and validated again. Without a validator, the one of the monitor is used.
+func (q *Queue) SetValidator(validator JobValidationFunc) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.validator = validator
+}
+
+func (q *Queue) jobValidator() JobValidationFunc {
+	q.m.Lock()
+	defer q.m.Unlock()
+	if q.validator != nil {
+		return q.validator
+	}
+	return q.monitor.isValid
+}
+
+// jobComplete asks the completion detector whether the job that is currently spooling is done.
+func (q *Queue) jobComplete() (bool, error) {
+	if q.Port.delimitsJobs() {
+		return false, nil
+	}
+
+	q.m.Lock()
+	detector := q.detector
+	timeout := q.timeoutWhileLocked()
+	lastActivity := q.lastActivity
+	q.m.Unlock()
+
+	s, err := readSpoolStatus(q.File)
+	if err != nil {
+		return false, err
+	}
+	s.Idle = time.Since(lastActivity)
+	s.Timeout = timeout
+	return detector.JobComplete(s), nil
+}
+
+func (q *Queue) start() error {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	if !q.state.allows(StateRunning) {
+		return fmt.Errorf("Cannot start queue %s in state %s", q.name, q.state)
+	}
+
+	binder, err := newPortBinder(q.Port)
+	if err != nil {
+		return err
+	}
+
+	err = q.resetWhileLocked()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if q.state != StateRunning {
+			os.Remove(q.File)
+		}
+	}()
+
+	err = binder.bind(q)
+	if err != nil {
+		return err
+	}
+
+	q.binder = binder
+	if err = q.state.transition(StateRunning); err != nil {
+		binder.unbind(q)
+		return err
+	}
+	log.Infof("Started queue for %s", q.File)
+	q.publish(QueueStarted, nil, 0, nil)
+
+	return nil
+}
+
+func (q *Queue) stop() {
+	q.m.Lock()
+	if q.state != StateRunning {
+		q.m.Unlock()
+		return
+	}
+	binder := q.binder
+	q.binder = nil
+	q.state.transition(StateStopped)
+	q.m.Unlock()
+
+	// Binders wait for their capturing goroutine, which needs the lock to write to the spool file
+	err := binder.unbind(q)
+	if err != nil {
+		log.Error(q.publishError(err))
+	}
+
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	if q.job != nil {
+		// keep the data of the interrupted job, it will be recovered on the next start
+		log.Warnf("Stopping queue %s while spooling job %s", q.name, q.job.Name)
+	} else {
+		err = os.Remove(q.File)
+		if err != nil && !os.IsNotExist(err) {
+			log.Error(err)
+		}
+	}
+
+	log.Infof("Stopped queue for %s", q.File)
+	q.publish(QueueStopped, nil, 0, nil)
+}
+
+// appendSpool adds data captured by a port binder to the spool file.
+// The file is reopened for every write because resetting the queue
+// replaces it with a fresh one.
+func (q *Queue) appendSpool(data []byte) error {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	f, err := os.OpenFile(q.File, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(data)
+	return err
+}
+
+func (q *Queue) startJob() (*Job, error) {
+
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	if q.job != nil {
+		return nil, fmt.Errorf("Queue %s already has a job", q.name)
+	}
+
+	q.job = q.newJob(time.Now())
+	q.monitor.journal.record(q.job, JobSpooling, nil)
+	q.monitor.updateSpooling(1)
+	q.publish(JobStarted, q.job, fileSize(q.File), nil)
+	return q.job, nil
+}
+
+// progress reports the number of bytes spooled for the current job.
+func (q *Queue) progress() {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	if q.job != nil {
+		q.publish(JobProgress, q.job, fileSize(q.File), nil)
+	}
+}
+
+func (q *Queue) newJob(t time.Time) *Job {
+	name := q.monitor.newJobID(t)
+	file := filepath.Join(filepath.Dir(q.File), name+".txt")
+	printer := q.Settings.Get("printer")
+	return &Job{
+		Time:      t,
+		Name:      name,
+		Device:    q.Device,
+		queue:     q,
+		queueFile: filepath.Base(q.File),
+		File:      file,
+		Printer:   printer,
+		monitor:   q.monitor,
+		record: JobRecord{
+			ID:      name,
+			Device:  q.Device,
+			Queue:   q.name,
+			File:    file,
+			Started: t,
+			Printer: printer,
+		},
+	}
+}
+
+// finishJob detaches a submitted job from the queue, resets the spool file for the next job
+// and hands the job to the work queue.
+func (q *Queue) finishJob(j *Job) error {
+	q.detachJob(j)
+	return q.handOff(j)
+}
+
+// detachJob removes the job from the queue and resets the spool file for the next job. It reports
+// whether the job was still attached.
+func (q *Queue) detachJob(j *Job) bool {
+	q.m.Lock()
+	defer q.m.Unlock()
+	if q.job != j {
+		return false
+	}
+	q.job = nil
+	if err := q.resetWhileLocked(); err != nil {
+		log.Errorf("Could not reset spool file %s: %s", q.File, q.publishError(err))
+	}
+	return true
+}
+
+func (q *Queue) resetWhileLocked() error {
+
+	// We have to actually remove the file instead of relying on os.Create()
+	// to truncate it because there could be an active job whose file is
+	// hardlinked to the spool file, and we need to break that connection
+	err := os.Remove(q.File)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// Create empty spool file
+	f, err := os.Create(q.File)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return nil
+}
+
+func (q *Queue) submitJob() {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	// the ticker keeps asking while validation holds the job back
+	if q.job != nil && !q.submitting {
+		job := q.job
+		q.submitting = true
+		app.Go(func() {
+			if err := job.submit(); err != nil {
+				q.submitFailed(job, err)
+			}
+			q.m.Lock()
+			q.submitting = false
+			q.m.Unlock()
+		})
+	}
+}
+
+// submitFailed deals with a job that could not be submitted. A job whose file could not be created
+// is dropped, so that the queue can capture the next job. A job that could not be validated stays
+// attached and is tried again on the next tick, unless the port delimits jobs, as the ticker never
+// completes those. A job that was submitted, but not accepted by the work queue, has already been
+// dropped by handOff and is recovered after a restart.
+func (q *Queue) submitFailed(j *Job, err error) {
+	log.Errorf("Could not submit job %s of queue %s: %s", j.Name, q.Name(), q.publishError(err))
+
+	j.m.Lock()
+	retry := j.linked && !j.submitted && !q.Port.delimitsJobs()
+	j.m.Unlock()
+	if retry {
+		return
+	}
+
+	// another attempt might have dropped the job already
+	if !q.detachJob(j) {
+		return
+	}
+	q.monitor.journal.record(j, JobFailed, err)
+	q.monitor.updateSpooling(-1)
+	q.publish(JobDropped, j, 0, err)
+}
+
+// JobInfo describes a job that is handed to a queue directly instead of being written to its device.
+type JobInfo struct {
+	User  string
+	Host  string
+	Title string
+}
+
+// Receive creates a job from data that arrived through another channel than the device of the queue,
+// like a network protocol, and submits it. The spool file of the queue is not involved, so this does
+// not interfere with a job that is currently spooling.
+func (q *Queue) Receive(r io.Reader, info JobInfo) (*Job, error) {
+	q.m.Lock()
+	if q.state != StateRunning {
+		q.m.Unlock()
+		return nil, fmt.Errorf("Cannot receive job for queue %s in state %s", q.name, q.state)
+	}
+	j := q.newJob(time.Now())
+	q.m.Unlock()
+
+	j.User = info.User
+	j.Host = info.Host
+	j.Title = info.Title
+	j.submitted = true
+
+	err := func() error {
+		f, err := os.OpenFile(j.File, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err = io.Copy(f, r); err != nil {
+			return err
+		}
+		return f.Sync()
+	}()
+	if err != nil {
+		os.Remove(j.File)
+		return nil, q.publishError(err)
+	}
+
+	q.publish(JobStarted, j, fileSize(j.File), nil)
+	q.monitor.journal.record(j, JobSubmitted, nil)
+	q.publish(JobCompleted, j, fileSize(j.File), nil)
+	if err = q.handOff(j); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// flush submits the job that is currently spooling without validating it first.
+func (q *Queue) flush() error {
+	q.m.Lock()
+	job := q.job
+	q.m.Unlock()
+
+	if job == nil {
+		return nil
+	}
+	return job.trySubmit(false)
 }
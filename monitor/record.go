@@ -0,0 +1,136 @@
+package monitor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// JobRecord describes a job and what became of it.
+type JobRecord struct {
+	ID          string
+	Device      string
+	Queue       string // name of the queue that received the job
+	File        string
+	Size        int64 // number of bytes, known once the job has been submitted
+	Started     time.Time
+	Finished    time.Time // zero until the job is done or has failed
+	Language    string    // detected print language: pjl, pcl, pdf, postscript or text
+	Pages       int       // number of pages, 0 until the consumer has counted them
+	Printer     string
+	User        string
+	Host        string
+	Title       string
+	Status      JobState
+	Error       string
+	DuplicateOf string // the job this one duplicates, see DuplicatePolicy
+}
+
+// newJobID creates a unique job name that sorts by the time the job was started. The sequence
+// number separates jobs started within the same millisecond, it starts at a value derived from the
+// startup time so that the names also stay unique across restarts.
+func (m *Monitor) newJobID(t time.Time) string {
+	seq := atomic.AddUint32(&m.jobSeq, 1) & 0xffff
+	return fmt.Sprintf("%s%s%03d-%04x", jobFilePrefix, t.Format("060102-150405"), t.Nanosecond()/int(time.Millisecond), seq)
+}
+
+// Record returns the metadata of the job.
+func (j *Job) Record() JobRecord {
+	j.rm.Lock()
+	defer j.rm.Unlock()
+	return j.record
+}
+
+// SetPages records the number of pages of the job. It is written to the journal with the next
+// state change of the job.
+func (j *Job) SetPages(pages int) {
+	j.rm.Lock()
+	defer j.rm.Unlock()
+	j.record.Pages = pages
+}
+
+// update tracks the state transitions of the job in its metadata.
+func (j *Job) update(state JobState, err error) {
+	var size int64
+	var language string
+	if state == JobSubmitted {
+		size = fileSize(j.File)
+		language = detectLanguage(j.File)
+	}
+
+	j.rm.Lock()
+	defer j.rm.Unlock()
+	j.record.Status = state
+	j.record.Error = ""
+	if err != nil {
+		j.record.Error = err.Error()
+	}
+	if state == JobSubmitted {
+		j.record.Size = size
+		j.record.Language = language
+	}
+	if state.Finished() {
+		j.record.Finished = time.Now()
+	}
+	// these can be set by the receiver of the job after it was created
+	j.record.Printer = j.Printer
+	j.record.User = j.User
+	j.record.Host = j.Host
+	j.record.Title = j.Title
+}
+
+// JobRecords returns the records of all jobs in the journal, ordered by their IDs.
+func (m *Monitor) JobRecords() []JobRecord {
+	entries := m.journal.latest()
+	records := make([]JobRecord, 0, len(entries))
+	for _, entry := range entries {
+		records = append(records, entry.record())
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID < records[j].ID
+	})
+	return records
+}
+
+var pjlLanguage = regexp.MustCompile(`@PJL\s+ENTER\s+LANGUAGE\s*=\s*([A-Za-z]+)`)
+
+// detectLanguage guesses the print language of a job from the start of its file.
+func detectLanguage(file string) string {
+	f, err := os.Open(file)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	head := make([]byte, spoolTailSize)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return ""
+	}
+	head = head[:n]
+
+	if match := pjlLanguage.FindSubmatch(head); match != nil {
+		return strings.ToLower(string(match[1]))
+	}
+	data := bytes.TrimLeft(head, trailings)
+	switch {
+	case len(data) == 0:
+		return ""
+	case bytes.HasPrefix(data, []byte("%PDF")):
+		return "pdf"
+	case bytes.HasPrefix(data, []byte("%!")):
+		return "postscript"
+	case bytes.HasPrefix(data, uec):
+		return "pjl"
+	case bytes.IndexByte(data, 0x1b) >= 0:
+		return "pcl"
+	default:
+		return "text"
+	}
+}
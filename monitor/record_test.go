@@ -0,0 +1,46 @@
+package monitor
+
+import (
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewJobID(t *testing.T) {
+	m := NewMonitor(t.TempDir(), nil)
+	now := time.Date(2021, 3, 1, 14, 5, 9, 123456789, time.Local)
+
+	id := m.newJobID(now)
+	if !regexp.MustCompile(`^pj-210301-140509123-[0-9a-f]{4}$`).MatchString(id) {
+		t.Errorf("got job id %s", id)
+	}
+	if later := m.newJobID(now.Add(time.Millisecond)); later <= id {
+		t.Errorf("job id %s does not sort after %s", later, id)
+	}
+}
+
+func TestNewJobIDUnique(t *testing.T) {
+	m := NewMonitor(t.TempDir(), nil)
+	now := time.Now()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	ids := make(map[string]bool)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := 0; k < 500; k++ {
+				id := m.newJobID(now)
+				mu.Lock()
+				if ids[id] {
+					t.Errorf("duplicate job id %s", id)
+				}
+				ids[id] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,215 @@
+package monitor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultRetentionInterval = 10 * time.Minute
+	jobFilePrefix            = "pj-"
+	// job files that are not in the journal are left alone for this long, they may belong to a
+	// job that is still being written
+	unjournaledGracePeriod = 10 * time.Minute
+)
+
+// RetentionPolicy determines how long the files of finished jobs are kept in the spool directory.
+// A zero value disables the corresponding limit, the zero policy keeps everything.
+type RetentionPolicy struct {
+	MaxAge       time.Duration // remove jobs that finished longer ago than this
+	FailedMaxAge time.Duration // like MaxAge, but for failed jobs, defaults to MaxAge
+	MaxTotalSize int64         // remove the oldest jobs until the files of all jobs fit into this many bytes
+	KeepLast     int           // never remove the latest jobs of each device
+	Interval     time.Duration // how often the policy is applied, defaults to DefaultRetentionInterval
+}
+
+func (p RetentionPolicy) enabled() bool {
+	return p.MaxAge > 0 || p.FailedMaxAge > 0 || p.MaxTotalSize > 0
+}
+
+func (p RetentionPolicy) maxAge(state JobState) time.Duration {
+	if state == JobFailed && p.FailedMaxAge > 0 {
+		return p.FailedMaxAge
+	}
+	return p.MaxAge
+}
+
+// SetRetentionPolicy configures the removal of finished jobs. Must be called before Start.
+func (m *Monitor) SetRetentionPolicy(policy RetentionPolicy) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	m.retention = policy
+}
+
+// retainedJob groups the files of a finished job in the spool directory.
+type retainedJob struct {
+	name      string
+	device    string
+	state     JobState
+	time      time.Time
+	files     []string
+	size      int64
+	protected bool
+}
+
+func (r *retainedJob) remove() error {
+	for _, file := range r.files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// retainedJobs collects the finished jobs from the journal and the job files in the spool directory
+// that are not covered by the journal. Files that belong to jobs in flight are never included.
+func (m *Monitor) retainedJobs(now time.Time) ([]*retainedJob, error) {
+	fis, err := ioutil.ReadDir(m.path)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]os.FileInfo)
+	for _, fi := range fis {
+		if fi.Mode().IsRegular() && strings.HasPrefix(fi.Name(), jobFilePrefix) {
+			files[fi.Name()] = fi
+		}
+	}
+
+	// a job owns its file and all files derived from it, like the sanitized data of a print job
+	claim := func(r *retainedJob, file string) {
+		base := strings.TrimSuffix(file, filepath.Ext(file))
+		for name, fi := range files {
+			if name == file || strings.HasPrefix(name, base+"-") {
+				r.files = append(r.files, filepath.Join(m.path, name))
+				r.size += fi.Size()
+				delete(files, name)
+			}
+		}
+	}
+
+	jobs := make([]*retainedJob, 0)
+	for _, entry := range m.journal.latest() {
+		r := &retainedJob{
+			name:   entry.Job,
+			device: entry.Device,
+			state:  entry.State,
+			time:   entry.Time,
+		}
+		claim(r, filepath.Base(entry.File))
+		if entry.State.Finished() && len(r.files) > 0 {
+			jobs = append(jobs, r)
+		}
+	}
+
+	// leftovers from before the journal or from jobs that have already been forgotten
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	// a job file sorts before the files derived from it
+	sort.Slice(names, func(i, j int) bool {
+		if len(names[i]) != len(names[j]) {
+			return len(names[i]) < len(names[j])
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		fi, found := files[name]
+		if !found {
+			continue
+		}
+		r := &retainedJob{
+			name:  strings.TrimSuffix(name, filepath.Ext(name)),
+			state: JobDone,
+			time:  fi.ModTime(),
+		}
+		claim(r, name)
+		if now.Sub(r.time) < unjournaledGracePeriod {
+			continue
+		}
+		jobs = append(jobs, r)
+	}
+
+	return jobs, nil
+}
+
+// collectGarbage applies the retention policy to the spool directory.
+func (m *Monitor) collectGarbage(policy RetentionPolicy, now time.Time) error {
+	jobs, err := m.retainedJobs(now)
+	if err != nil {
+		return err
+	}
+
+	// newest first
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].time.After(jobs[j].time)
+	})
+
+	kept := make(map[string]int)
+	for _, r := range jobs {
+		if kept[r.device] < policy.KeepLast {
+			r.protected = true
+			kept[r.device]++
+		}
+	}
+
+	remove := func(r *retainedJob, reason string) bool {
+		if err := r.remove(); err != nil {
+			log.Errorf("Could not remove files of job %s: %s", r.name, err)
+			return false
+		}
+		log.Infof("Removed %s job %s (%s)", r.state, r.name, reason)
+		m.journal.forget(r.name)
+		return true
+	}
+
+	remaining := make([]*retainedJob, 0, len(jobs))
+	var total int64
+	for _, r := range jobs {
+		maxAge := policy.maxAge(r.state)
+		if !r.protected && maxAge > 0 && now.Sub(r.time) > maxAge {
+			if remove(r, "expired") {
+				continue
+			}
+		}
+		remaining = append(remaining, r)
+		total += r.size
+	}
+
+	if policy.MaxTotalSize <= 0 || total <= policy.MaxTotalSize {
+		return nil
+	}
+
+	// remove the oldest jobs first, failed jobs only once all other jobs are gone
+	candidates := make([]*retainedJob, 0, len(remaining))
+	for _, r := range remaining {
+		if !r.protected {
+			candidates = append(candidates, r)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		failedI, failedJ := candidates[i].state == JobFailed, candidates[j].state == JobFailed
+		if failedI != failedJ {
+			return failedJ
+		}
+		return candidates[i].time.Before(candidates[j].time)
+	})
+	for _, r := range candidates {
+		if total <= policy.MaxTotalSize {
+			break
+		}
+		if remove(r, "spool directory too large") {
+			total -= r.size
+		}
+	}
+	if total > policy.MaxTotalSize {
+		log.Warnf("Spool directory %s still uses %d bytes after removing old jobs", m.path, total)
+	}
+	return nil
+}
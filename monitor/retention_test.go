@@ -0,0 +1,99 @@
+package monitor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.Local)
+	jobs := []struct {
+		name    string
+		state   JobState // invalidJobState for files that are not in the journal
+		age     time.Duration
+		derived bool // the job has a derived file like the sanitized data of a print job
+	}{
+		{"pj-a", JobDone, 3 * time.Hour, true},
+		{"pj-b", JobDone, 2 * time.Hour, false},
+		{"pj-c", JobFailed, 150 * time.Minute, false},
+		{"pj-d", JobDone, 30 * time.Minute, false},
+		{"pj-e", JobSpooling, 5 * time.Hour, false},
+		{"pj-f", JobSubmitted, 5 * time.Hour, false},
+		{"pj-g", invalidJobState, 90 * time.Minute, false},
+		{"pj-h", invalidJobState, time.Minute, false},
+	}
+
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		want   string
+	}{
+		{"keep everything", RetentionPolicy{}, "a a-x b c d e f g h"},
+		{"max age", RetentionPolicy{MaxAge: time.Hour}, "d e f h"},
+		{"failed max age", RetentionPolicy{MaxAge: time.Hour, FailedMaxAge: 24 * time.Hour}, "c d e f h"},
+		{"keep last", RetentionPolicy{MaxAge: time.Hour, KeepLast: 2}, "b d e f g h"},
+		{"max total size", RetentionPolicy{MaxTotalSize: 30}, "c d e f g h"},
+		{"max total size keeps failed jobs", RetentionPolicy{MaxTotalSize: 10}, "c e f h"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			m := NewMonitor(dir, nil)
+			entries := make([]*journalEntry, 0)
+			for _, j := range jobs {
+				file := filepath.Join(dir, j.name+".prn")
+				files := []string{file}
+				if j.derived {
+					files = append(files, filepath.Join(dir, j.name+"-x.prn"))
+				}
+				for _, f := range files {
+					if err := ioutil.WriteFile(f, []byte("0123456789"), 0644); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.Chtimes(f, now.Add(-j.age), now.Add(-j.age)); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if j.state != invalidJobState {
+					entries = append(entries, &journalEntry{Job: j.name, Device: "LPT1", File: file, State: j.state, Time: now.Add(-j.age)})
+				}
+			}
+			if err := m.journal.open(entries); err != nil {
+				t.Fatal(err)
+			}
+			defer m.journal.close()
+
+			if err := m.collectGarbage(tt.policy, now); err != nil {
+				t.Fatal(err)
+			}
+
+			fis, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			kept := make([]string, 0)
+			for _, fi := range fis {
+				if strings.HasPrefix(fi.Name(), jobFilePrefix) {
+					kept = append(kept, strings.TrimSuffix(strings.TrimPrefix(fi.Name(), jobFilePrefix), ".prn"))
+				}
+			}
+			sort.Strings(kept)
+			if got := strings.Join(kept, " "); got != tt.want {
+				t.Errorf("kept %q, want %q", got, tt.want)
+			}
+
+			// removed jobs disappear from the journal
+			for _, entry := range m.journal.latest() {
+				if _, err := os.Stat(entry.File); os.IsNotExist(err) {
+					t.Errorf("journal still contains removed job %s", entry.Job)
+				}
+			}
+		})
+	}
+}
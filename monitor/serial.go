@@ -0,0 +1,139 @@
+package monitor
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type Parity int
+
+const (
+	ParityNone Parity = iota
+	ParityOdd
+	ParityEven
+)
+
+func (p Parity) String() string {
+	switch p {
+	case ParityNone:
+		return "none"
+	case ParityOdd:
+		return "odd"
+	case ParityEven:
+		return "even"
+	default:
+		return fmt.Sprintf("UNKNOWN PARITY: %d", p)
+	}
+}
+
+func ParseParity(s string) (Parity, error) {
+	switch strings.ToLower(s) {
+	case "", "none":
+		return ParityNone, nil
+	case "odd":
+		return ParityOdd, nil
+	case "even":
+		return ParityEven, nil
+	default:
+		return ParityNone, fmt.Errorf("Unknown parity: %s", s)
+	}
+}
+
+// SerialSettings describe the line of a serial port that a queue captures from.
+type SerialSettings struct {
+	Port     string // the serial device to read from, like COM2 or /dev/ttyS1
+	Baud     int
+	DataBits int
+	Parity   Parity
+	StopBits int
+	XonXoff  bool // software flow control
+}
+
+// withDefaults fills in the settings that have not been configured with 9600 8N1.
+func (s SerialSettings) withDefaults() SerialSettings {
+	if s.Baud == 0 {
+		s.Baud = 9600
+	}
+	if s.DataBits == 0 {
+		s.DataBits = 8
+	}
+	if s.StopBits == 0 {
+		s.StopBits = 1
+	}
+	return s
+}
+
+// Equal reports whether both settings configure the line the same way once the defaults are applied.
+func (s SerialSettings) Equal(o SerialSettings) bool {
+	return s.withDefaults() == o.withDefaults()
+}
+
+func (s SerialSettings) validate() error {
+	if s.Port == "" {
+		return fmt.Errorf("Missing serial port")
+	}
+	if s.DataBits < 5 || s.DataBits > 8 {
+		return fmt.Errorf("Invalid number of data bits: %d", s.DataBits)
+	}
+	if s.StopBits != 1 && s.StopBits != 2 {
+		return fmt.Errorf("Invalid number of stop bits: %d", s.StopBits)
+	}
+	if s.Baud <= 0 {
+		return fmt.Errorf("Invalid baud rate: %d", s.Baud)
+	}
+	return nil
+}
+
+func (s SerialSettings) String() string {
+	flow := ""
+	if s.XonXoff {
+		flow = " XON/XOFF"
+	}
+	return fmt.Sprintf("%s %d %d%s%d%s", s.Port, s.Baud, s.DataBits, strings.ToUpper(s.Parity.String()[:1]), s.StopBits, flow)
+}
+
+// AddSerialPort adds a device that captures the print data arriving on a serial port.
+func (m *Monitor) AddSerialPort(device string, file string, name string, timeout time.Duration, settings SerialSettings) (queue *Queue, err error) {
+
+	if file != filepath.Base(file) {
+		return nil, fmt.Errorf("filename must not contain path components: %s", file)
+	}
+
+	settings = settings.withDefaults()
+	if err = settings.validate(); err != nil {
+		return nil, fmt.Errorf("Invalid serial settings for device %s: %s", device, err)
+	}
+
+	return m.addQueue(file, &Queue{
+		Device:   device,
+		File:     filepath.Join(m.path, file),
+		name:     name,
+		Port:     PortSerial,
+		Serial:   settings,
+		Settings: &dummySettings{},
+		state:    StateValid,
+		monitor:  m,
+		timeout:  timeout,
+		detector: IdleTimeout(),
+	})
+}
+
+// AddCOMPort captures the data written to the serial port COM<port>. The data is read from the same port
+// unless the settings name another one, like the other end of a virtual null modem.
+func (m *Monitor) AddCOMPort(port int, name string, settings SerialSettings) (queue *Queue, err error) {
+
+	device := fmt.Sprintf("COM%d", port)
+
+	if port < 1 || port > 256 {
+		err = fmt.Errorf("Invalid device %s, only support COM1 - COM256", device)
+		return
+	}
+
+	if settings.Port == "" {
+		settings.Port = device
+	}
+
+	return m.AddSerialPort(device, fmt.Sprintf("com-%d.txt", port), name, 1000*time.Millisecond, settings)
+}
@@ -0,0 +1,129 @@
+// +build linux
+
+package monitor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+var baudRates = map[int]uint32{
+	300:    unix.B300,
+	1200:   unix.B1200,
+	2400:   unix.B2400,
+	4800:   unix.B4800,
+	9600:   unix.B9600,
+	19200:  unix.B19200,
+	38400:  unix.B38400,
+	57600:  unix.B57600,
+	115200: unix.B115200,
+	230400: unix.B230400,
+}
+
+var dataBits = map[int]uint32{
+	5: unix.CS5,
+	6: unix.CS6,
+	7: unix.CS7,
+	8: unix.CS8,
+}
+
+// serialDevicePath maps Windows style names like COM1 to the corresponding tty.
+func serialDevicePath(port string) string {
+	if filepath.IsAbs(port) {
+		return port
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(port), "COM")); err == nil && n > 0 {
+		return fmt.Sprintf("/dev/ttyS%d", n-1)
+	}
+	return filepath.Join("/dev", port)
+}
+
+// serialBinder reads the print data from a tty.
+type serialBinder struct {
+	f    *os.File
+	done chan struct{}
+}
+
+func (b *serialBinder) bind(q *Queue) (err error) {
+	s := q.Serial
+	baud, found := baudRates[s.Baud]
+	if !found {
+		return fmt.Errorf("Unsupported baud rate: %d", s.Baud)
+	}
+
+	path := serialDevicePath(s.Port)
+	f, err := os.OpenFile(path, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+		}
+	}()
+
+	// Calling Fd() would switch the file to blocking mode, and we could no longer
+	// interrupt the pending read when unbinding
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	cerr := rc.Control(func(fd uintptr) {
+		var termios *unix.Termios
+		termios, err = unix.IoctlGetTermios(int(fd), unix.TCGETS)
+		if err != nil {
+			return
+		}
+		termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON | unix.IXOFF | unix.IXANY
+		termios.Oflag &^= unix.OPOST
+		termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		termios.Cflag &^= unix.CBAUD | unix.CSIZE | unix.PARENB | unix.PARODD | unix.CSTOPB | unix.CRTSCTS
+		termios.Cflag |= baud | dataBits[s.DataBits] | unix.CREAD | unix.CLOCAL
+		termios.Ispeed = baud
+		termios.Ospeed = baud
+		switch s.Parity {
+		case ParityOdd:
+			termios.Cflag |= unix.PARENB | unix.PARODD
+		case ParityEven:
+			termios.Cflag |= unix.PARENB
+		}
+		if s.StopBits == 2 {
+			termios.Cflag |= unix.CSTOPB
+		}
+		if s.XonXoff {
+			// the driver consumes XON/XOFF from the line and throttles the sender when we fall behind
+			termios.Iflag |= unix.IXON | unix.IXOFF
+		}
+		termios.Cc[unix.VMIN] = 1
+		termios.Cc[unix.VTIME] = 0
+		err = unix.IoctlSetTermios(int(fd), unix.TCSETS, termios)
+	})
+	if err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Capturing %s from serial port %s", q.Device, s)
+
+	b.f = f
+	b.done = make(chan struct{})
+	go func() {
+		defer close(b.done)
+		pump(q, f)
+	}()
+	return nil
+}
+
+func (b *serialBinder) unbind(q *Queue) error {
+	err := b.f.Close()
+	<-b.done
+	return err
+}
@@ -0,0 +1,198 @@
+// +build windows
+
+package monitor
+
+import (
+	"strings"
+	"sync"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows"
+)
+
+var (
+	procGetCommState = windows.NewLazySystemDLL("kernel32.dll").NewProc("GetCommState")
+	procSetCommState = windows.NewLazySystemDLL("kernel32.dll").NewProc("SetCommState")
+)
+
+// dcb mirrors the DCB structure of the Win32 API
+type dcb struct {
+	DCBlength  uint32
+	BaudRate   uint32
+	Flags      uint32
+	wReserved  uint16
+	XonLim     uint16
+	XoffLim    uint16
+	ByteSize   byte
+	Parity     byte
+	StopBits   byte
+	XonChar    byte
+	XoffChar   byte
+	ErrorChar  byte
+	EofChar    byte
+	EvtChar    byte
+	wReserved1 uint16
+}
+
+// flags of the DCB bit field
+const (
+	dcbBinary      = 1 << 0
+	dcbParity      = 1 << 1
+	dcbOutxCtsFlow = 1 << 2
+	dcbOutxDsrFlow = 1 << 3
+	dcbDtrControl  = 3 << 4
+	dcbDtrEnable   = 1 << 4
+	dcbOutX        = 1 << 8
+	dcbInX         = 1 << 9
+	dcbRtsControl  = 3 << 12
+	dcbRtsEnable   = 1 << 12
+)
+
+const (
+	noParity    = 0
+	oddParity   = 1
+	evenParity  = 2
+	oneStopBit  = 0
+	twoStopBits = 2
+	xon         = 0x11
+	xoff        = 0x13
+)
+
+func getCommState(h windows.Handle, state *dcb) error {
+	r, _, err := procGetCommState.Call(uintptr(h), uintptr(unsafe.Pointer(state)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+func setCommState(h windows.Handle, state *dcb) error {
+	r, _, err := procSetCommState.Call(uintptr(h), uintptr(unsafe.Pointer(state)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+func serialDevicePath(port string) string {
+	if strings.HasPrefix(port, `\\`) {
+		return port
+	}
+	// COM10 and up are only reachable through the device namespace
+	return `\\.\` + port
+}
+
+// serialBinder reads the print data from a COM port.
+type serialBinder struct {
+	m      sync.Mutex
+	h      windows.Handle
+	closed bool
+	done   chan struct{}
+}
+
+func (b *serialBinder) bind(q *Queue) (err error) {
+	s := q.Serial
+
+	path, err := windows.UTF16PtrFromString(serialDevicePath(s.Port))
+	if err != nil {
+		return err
+	}
+	h, err := windows.CreateFile(path, windows.GENERIC_READ|windows.GENERIC_WRITE, 0, nil, windows.OPEN_EXISTING, 0, 0)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			windows.CloseHandle(h)
+		}
+	}()
+
+	state := &dcb{}
+	state.DCBlength = uint32(unsafe.Sizeof(*state))
+	if err = getCommState(h, state); err != nil {
+		return err
+	}
+	state.BaudRate = uint32(s.Baud)
+	state.ByteSize = byte(s.DataBits)
+	state.Flags &^= dcbParity | dcbOutxCtsFlow | dcbOutxDsrFlow | dcbDtrControl | dcbOutX | dcbInX | dcbRtsControl
+	state.Flags |= dcbBinary | dcbDtrEnable | dcbRtsEnable
+	switch s.Parity {
+	case ParityOdd:
+		state.Parity = oddParity
+		state.Flags |= dcbParity
+	case ParityEven:
+		state.Parity = evenParity
+		state.Flags |= dcbParity
+	default:
+		state.Parity = noParity
+	}
+	state.StopBits = oneStopBit
+	if s.StopBits == 2 {
+		state.StopBits = twoStopBits
+	}
+	if s.XonXoff {
+		// the driver consumes XON/XOFF from the line and throttles the sender when we fall behind
+		state.Flags |= dcbOutX | dcbInX
+		state.XonChar = xon
+		state.XoffChar = xoff
+		state.XonLim = 2048
+		state.XoffLim = 512
+	}
+	if err = setCommState(h, state); err != nil {
+		return err
+	}
+
+	// Return from reads after a short while even if nothing arrived, so that unbinding does
+	// not have to wait for the next byte on the line
+	timeouts := &windows.CommTimeouts{
+		ReadIntervalTimeout:        0xffffffff,
+		ReadTotalTimeoutMultiplier: 0xffffffff,
+		ReadTotalTimeoutConstant:   200,
+	}
+	if err = windows.SetCommTimeouts(h, timeouts); err != nil {
+		return err
+	}
+
+	log.Infof("Capturing %s from serial port %s", q.Device, s)
+
+	b.h = h
+	b.done = make(chan struct{})
+	go func() {
+		defer close(b.done)
+		b.pump(q)
+	}()
+	return nil
+}
+
+func (b *serialBinder) isClosed() bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.closed
+}
+
+// pump has to read from the handle itself, os.File would take a read timeout for the end of the file.
+func (b *serialBinder) pump(q *Queue) {
+	buf := make([]byte, 32*1024)
+	for !b.isClosed() {
+		var n uint32
+		err := windows.ReadFile(b.h, buf, &n, nil)
+		if n > 0 {
+			if err := q.appendSpool(buf[:n]); err != nil {
+				log.Errorf("Could not write to spool file %s: %s", q.File, err)
+			}
+		}
+		if err != nil {
+			log.Debugf("Stopped capturing device %s: %s", q.Device, err)
+			return
+		}
+	}
+}
+
+func (b *serialBinder) unbind(q *Queue) error {
+	b.m.Lock()
+	b.closed = true
+	b.m.Unlock()
+	<-b.done
+	return windows.CloseHandle(b.h)
+}
@@ -0,0 +1,121 @@
+package monitor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// stateTransitions lists the valid state changes. The monitor goes through StateStarting while it
+// recovers jobs and starts its queues, queues go to StateRunning directly.
+var stateTransitions = map[State][]State{
+	StateValid:    {StateStarting, StateRunning},
+	StateStarting: {StateRunning, StateInvalid},
+	StateRunning:  {StateStopped, StateInvalid},
+}
+
+func (s State) allows(to State) bool {
+	for _, state := range stateTransitions[s] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
+
+// transition changes the state if the state machine allows it. The caller must hold the lock
+// that protects the state.
+func (s *State) transition(to State) error {
+	if !s.allows(to) {
+		return fmt.Errorf("Invalid state change from %s to %s", *s, to)
+	}
+	*s = to
+	return nil
+}
+
+// QueueStatus is a snapshot of the state of a queue.
+type QueueStatus struct {
+	Device        string
+	Name          string
+	File          string
+	Port          PortType
+	State         State
+	Held          bool      // completed jobs are parked until they are released
+	Job           string    // the job that is currently spooling, empty if the queue is idle
+	JobStarted    time.Time // start of the job that is currently spooling
+	Bytes         int64     // bytes spooled for the current job so far
+	LastActivity  time.Time // time of the last write to the spool file
+	LastError     string
+	LastErrorTime time.Time
+}
+
+// Spooling reports whether the queue was spooling a job when the snapshot was taken.
+func (s QueueStatus) Spooling() bool {
+	return s.Job != ""
+}
+
+// Status is a snapshot of the state of the monitor and all of its queues.
+type Status struct {
+	Time     time.Time
+	State    State
+	Spooling int           // number of jobs that are currently spooling
+	Queues   []QueueStatus // ordered by device
+}
+
+// Status returns a snapshot of the queue.
+func (q *Queue) Status() QueueStatus {
+	q.m.Lock()
+	s := QueueStatus{
+		Device:        q.Device,
+		Name:          q.name,
+		File:          q.File,
+		Port:          q.Port,
+		State:         q.state,
+		LastActivity:  q.lastActivity,
+		LastError:     q.lastError,
+		LastErrorTime: q.lastErrorTime,
+	}
+	if q.job != nil {
+		s.Job = q.job.Name
+		s.JobStarted = q.job.Time
+	}
+	q.m.Unlock()
+
+	s.Held = q.Held()
+	if s.Spooling() {
+		s.Bytes = fileSize(s.File)
+	}
+	return s
+}
+
+// Status returns a snapshot of the monitor and all of its queues. It can be called at any time
+// from any goroutine.
+func (m *Monitor) Status() Status {
+	m.m.Lock()
+	s := Status{
+		Time:  time.Now(),
+		State: m.state,
+	}
+	m.m.Unlock()
+
+	for _, queue := range m.Queues() {
+		qs := queue.Status()
+		if qs.Spooling() {
+			s.Spooling++
+		}
+		s.Queues = append(s.Queues, qs)
+	}
+	sort.Slice(s.Queues, func(i, j int) bool {
+		return strings.ToLower(s.Queues[i].Device) < strings.ToLower(s.Queues[j].Device)
+	})
+	return s
+}
+
+// recordError remembers the last error of the queue for the status.
+func (q *Queue) recordError(err error) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.lastError = err.Error()
+	q.lastErrorTime = time.Now()
+}
@@ -0,0 +1,54 @@
+package monitor
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStatus(t *testing.T) {
+	m := NewMonitor(t.TempDir(), nil)
+	for _, device := range []string{"net2", "NET1"} {
+		if _, err := m.AddTCPPort(device, device+".txt", "127.0.0.1:0", "Netz "+device); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := m.Status(); s.State != StateValid || len(s.Queues) != 2 || s.Queues[0].State != StateValid {
+		t.Errorf("got status %+v before start", s)
+	}
+
+	runMonitor(t, m)
+	q := m.Queue("NET1")
+	q.SetTimeout(time.Second)
+	q.SetHold(true)
+	q.SetName("Netzwerk")
+	q.m.Lock()
+	address := q.binder.(*tcpBinder).listener.Addr().String()
+	q.m.Unlock()
+
+	conn := sendTCP(t, address, "data")
+	defer conn.Close()
+	waitFor(t, "data to arrive", func() bool {
+		return q.Status().Bytes == 4
+	})
+
+	s := m.Status()
+	if s.State != StateRunning || s.Spooling != 1 || len(s.Queues) != 2 {
+		t.Fatalf("got status %+v", s)
+	}
+	qs := s.Queues[0]
+	if qs.Device != "NET1" || qs.Name != "Netzwerk" || qs.State != StateRunning || !qs.Held || qs.Port != PortTCP {
+		t.Errorf("got queue status %+v", qs)
+	}
+	if !qs.Spooling() || qs.JobStarted.IsZero() || qs.LastActivity.Before(qs.JobStarted) {
+		t.Errorf("got job %s started %s, last activity %s", qs.Job, qs.JobStarted, qs.LastActivity)
+	}
+	if qs := s.Queues[1]; qs.Device != "net2" || qs.Spooling() || qs.Held || qs.Bytes != 0 {
+		t.Errorf("got idle queue status %+v", qs)
+	}
+
+	q.publishError(errors.New("paper jam"))
+	if qs := q.Status(); qs.LastError != "paper jam" || qs.LastErrorTime.IsZero() {
+		t.Errorf("got error %q at %s", qs.LastError, qs.LastErrorTime)
+	}
+}
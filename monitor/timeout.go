@@ -0,0 +1,122 @@
+package monitor
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	// the extended timeout is this many times the regular timeout unless configured explicitly
+	extendedTimeoutFactor = 5
+	// number of observed gaps the adaptive timeout is based on
+	adaptiveSamples = 200
+	// the adaptive timeout only kicks in after this many observations
+	adaptiveMinSamples = 10
+	adaptivePercentile = 0.95
+	// only pauses of at least this fraction of the configured timeout are recorded, the gaps
+	// between the writes of a busy application would otherwise keep the percentile tiny
+	adaptiveGapFloor = 0.25
+	// the learned timeout is the percentile of the gaps times this factor
+	adaptiveHeadroom = 1.5
+	// upper bound for the adaptive timeout to keep runaway observations from stalling the queue
+	maxAdaptiveTimeout = time.Minute
+)
+
+// gapTracker records the longer pauses between writes to a queue and derives a timeout that
+// covers the typical pauses of the printing application.
+type gapTracker struct {
+	gaps    []time.Duration
+	next    int
+	learned time.Duration
+}
+
+func (g *gapTracker) add(gap time.Duration) {
+	if gap <= 0 || gap > maxAdaptiveTimeout {
+		return
+	}
+	if len(g.gaps) < adaptiveSamples {
+		g.gaps = append(g.gaps, gap)
+	} else {
+		g.gaps[g.next] = gap
+		g.next = (g.next + 1) % adaptiveSamples
+	}
+	g.update()
+}
+
+func (g *gapTracker) update() {
+	if len(g.gaps) < adaptiveMinSamples {
+		g.learned = 0
+		return
+	}
+	sorted := append([]time.Duration(nil), g.gaps...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	idx := int(float64(len(sorted)-1) * adaptivePercentile)
+	g.learned = time.Duration(float64(sorted[idx]) * adaptiveHeadroom)
+	if g.learned > maxAdaptiveTimeout {
+		g.learned = maxAdaptiveTimeout
+	}
+}
+
+// SetExtendTimeout switches the queue to the extended timeout for applications that pause while printing.
+func (q *Queue) SetExtendTimeout(extend bool) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.extendTimeout = extend
+}
+
+// SetExtendedTimeout sets the timeout used while the timeout is extended. If it is zero, the
+// extended timeout is a multiple of the regular timeout.
+func (q *Queue) SetExtendedTimeout(timeout time.Duration) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.extendedTimeout = timeout
+}
+
+// SetAdaptiveTimeout enables learning the timeout from the pauses between writes to the queue.
+// The learned timeout only ever widens the configured one.
+func (q *Queue) SetAdaptiveTimeout(adaptive bool) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.adaptiveTimeout = adaptive
+}
+
+// Timeout returns the idle timeout that is currently in effect for the queue.
+func (q *Queue) Timeout() time.Duration {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return q.timeoutWhileLocked()
+}
+
+func (q *Queue) timeoutWhileLocked() time.Duration {
+	timeout := q.timeout
+	if q.extendTimeout {
+		if q.extendedTimeout > 0 {
+			timeout = q.extendedTimeout
+		} else {
+			timeout *= extendedTimeoutFactor
+		}
+	}
+	if q.adaptiveTimeout && q.gaps.learned > timeout {
+		timeout = q.gaps.learned
+	}
+	return timeout
+}
+
+// recordActivity notes a write to the spool file. Pauses within a job feed the adaptive timeout,
+// and so does the pause before a job that starts shortly after the previous one completed, as that
+// is usually a single job that was split by a timeout that is too short.
+func (q *Queue) recordActivity(t time.Time, newJob bool) {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	if !q.lastActivity.IsZero() {
+		gap := t.Sub(q.lastActivity)
+		floor := time.Duration(float64(q.timeout) * adaptiveGapFloor)
+		if gap >= floor && (!newJob || gap < 2*q.timeoutWhileLocked()) {
+			q.gaps.add(gap)
+		}
+	}
+	q.lastActivity = t
+}
@@ -0,0 +1,201 @@
+package monitor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var pjlJob = []byte("@PJL JOB")
+
+// observations of SizeStable that have not been updated for this long are forgotten
+const sizeStableExpiry = 10 * time.Minute
+
+// PJLJobClosed accepts jobs that end with a UEC. Jobs that open a PJL job with @PJL JOB must also close it with @PJL EOJ.
+func PJLJobClosed() JobValidationFunc {
+	return func(f *os.File) bool {
+		s, err := readTail(f)
+		if err != nil || s.Size <= int64(len(uec)) {
+			return false
+		}
+		tail := trimmedTail(s)
+		if !bytes.HasSuffix(tail, uec) {
+			return false
+		}
+		head := make([]byte, spoolTailSize)
+		n, err := f.ReadAt(head, 0)
+		if err != nil && err != io.EOF {
+			return false
+		}
+		if !bytes.Contains(head[:n], pjlJob) {
+			return true
+		}
+		return bytes.LastIndex(tail, pjlEOJ) > bytes.LastIndex(tail, pjlJob)
+	}
+}
+
+// PCLEndsWithReset accepts jobs that end with a printer reset (ESC E).
+func PCLEndsWithReset() JobValidationFunc {
+	return func(f *os.File) bool {
+		s, err := readTail(f)
+		if err != nil {
+			return false
+		}
+		return s.Size > int64(len(pclReset)) && bytes.HasSuffix(trimmedTail(s), pclReset)
+	}
+}
+
+// PDFComplete accepts jobs that end with the %%EOF marker of a PDF file.
+func PDFComplete() JobValidationFunc {
+	return func(f *os.File) bool {
+		s, err := readTail(f)
+		if err != nil {
+			return false
+		}
+		return bytes.HasSuffix(trimmedTail(s), pdfEOF)
+	}
+}
+
+// MinSize accepts jobs with at least size bytes.
+func MinSize(size int64) JobValidationFunc {
+	return func(f *os.File) bool {
+		fi, err := f.Stat()
+		return err == nil && fi.Size() >= size
+	}
+}
+
+// SizeStable accepts a job once its size has not changed for the given number of consecutive
+// validations after the current size was first seen. Jobs are validated on every tick of the
+// monitor while they are held back.
+func SizeStable(checks int) JobValidationFunc {
+	var m sync.Mutex
+	type observation struct {
+		size   int64
+		stable int
+		seen   time.Time
+	}
+	observed := make(map[string]*observation)
+	return func(f *os.File) bool {
+		fi, err := f.Stat()
+		if err != nil {
+			return false
+		}
+		now := time.Now()
+		m.Lock()
+		defer m.Unlock()
+		// jobs that are no longer validated have been submitted or dropped
+		for name, o := range observed {
+			if now.Sub(o.seen) > sizeStableExpiry {
+				delete(observed, name)
+			}
+		}
+		o, found := observed[f.Name()]
+		if !found || o.size != fi.Size() {
+			o = &observation{size: fi.Size()}
+			observed[f.Name()] = o
+		} else {
+			o.stable++
+		}
+		o.seen = now
+		if o.stable >= checks {
+			delete(observed, f.Name())
+			return true
+		}
+		return false
+	}
+}
+
+// AllOf accepts a job if all validators do.
+func AllOf(validators ...JobValidationFunc) JobValidationFunc {
+	return func(f *os.File) bool {
+		for _, v := range validators {
+			if !v(f) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// OneOf accepts a job as soon as one of the validators does.
+func OneOf(validators ...JobValidationFunc) JobValidationFunc {
+	return func(f *os.File) bool {
+		for _, v := range validators {
+			if v(f) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+var validatorTerm = regexp.MustCompile(`^([a-z_]+)(?:\((\d+)\))?$`)
+
+// NewJobValidator builds a validator from an expression as used in the configuration, like
+// "pjl or pcl and min_size(512)". The terms are pjl, pcl, pdf, min_size(bytes) and stable(checks),
+// "and" binds tighter than "or". An empty expression returns nil, which accepts every job.
+func NewJobValidator(expression string) (JobValidationFunc, error) {
+	expression = strings.ToLower(strings.TrimSpace(expression))
+	if expression == "" {
+		return nil, nil
+	}
+	alternatives := make([]JobValidationFunc, 0)
+	for _, alternative := range strings.Split(expression, " or ") {
+		terms := make([]JobValidationFunc, 0)
+		for _, term := range strings.Split(alternative, " and ") {
+			v, err := newValidatorTerm(strings.TrimSpace(term))
+			if err != nil {
+				return nil, err
+			}
+			terms = append(terms, v)
+		}
+		if len(terms) == 1 {
+			alternatives = append(alternatives, terms[0])
+		} else {
+			alternatives = append(alternatives, AllOf(terms...))
+		}
+	}
+	if len(alternatives) == 1 {
+		return alternatives[0], nil
+	}
+	return OneOf(alternatives...), nil
+}
+
+func newValidatorTerm(term string) (JobValidationFunc, error) {
+	match := validatorTerm.FindStringSubmatch(term)
+	if match == nil {
+		return nil, fmt.Errorf("Invalid job validation term: %q", term)
+	}
+	name, arg := match[1], match[2]
+	switch name {
+	case "pjl", "pcl", "pdf":
+		if arg != "" {
+			return nil, fmt.Errorf("Job validation %s does not take an argument", name)
+		}
+	case "min_size", "stable":
+		if arg == "" {
+			return nil, fmt.Errorf("Job validation %s requires an argument", name)
+		}
+	}
+	n, _ := strconv.ParseInt(arg, 10, 64)
+	switch name {
+	case "pjl":
+		return PJLJobClosed(), nil
+	case "pcl":
+		return PCLEndsWithReset(), nil
+	case "pdf":
+		return PDFComplete(), nil
+	case "min_size":
+		return MinSize(n), nil
+	case "stable":
+		return SizeStable(int(n)), nil
+	default:
+		return nil, fmt.Errorf("Unknown job validation: %s", name)
+	}
+}
@@ -0,0 +1,125 @@
+package monitor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func openJobFile(t *testing.T, data string) *os.File {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "pj-test.prn")
+	if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestNewJobValidator(t *testing.T) {
+	const pjlJobData = "\x1b%-12345X@PJL JOB NAME=\"a\"\r\n\x1bEdata\x1bE"
+
+	tests := []struct {
+		expression string
+		data       string
+		want       bool
+	}{
+		{"pjl", "\x1b%-12345X\x1bEdata\x1b%-12345X", true},
+		{"pjl", "\x1bEdata\x1b%-12345X\r\n", true},
+		{"pjl", "\x1b%-12345X", false},
+		{"pjl", pjlJobData + "\x1b%-12345X", false},
+		{"pjl", pjlJobData + "\x1b%-12345X@PJL EOJ NAME=\"a\"\r\n\x1b%-12345X", true},
+		{"pcl", "\x1bEdata\x1bE\r\n", true},
+		{"pcl", "\x1bEdata", false},
+		{"pcl", "\x1bE", false},
+		{"pdf", "%PDF-1.4\n%%EOF", true},
+		{"pdf", "%PDF-1.4\n", false},
+		{"min_size(4)", "data", true},
+		{"min_size(5)", "data", false},
+		{"stable(1)", "data", false},
+		{"PCL or PDF", "%PDF-1.4\n%%EOF", true},
+		{"pcl or pdf", "data", false},
+		{"pcl and min_size(8)", "\x1bEdata\x1bE", true},
+		{"pcl and min_size(9)", "\x1bEdata\x1bE", false},
+		{"pdf or pcl and min_size(9)", "\x1bEdata\x1bE", false},
+		{"pdf or pcl and min_size(8)", "\x1bEdata\x1bE", true},
+		{"  pdf  or  pcl  ", "\x1bEdata\x1bE", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			validator, err := NewJobValidator(tt.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := validator(openJobFile(t, tt.data)); got != tt.want {
+				t.Errorf("got %v for %q, want %v", got, tt.data, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewJobValidatorEmpty(t *testing.T) {
+	validator, err := NewJobValidator("  ")
+	if err != nil || validator != nil {
+		t.Errorf("got %v, %v, want no validator", validator, err)
+	}
+}
+
+func TestNewJobValidatorInvalid(t *testing.T) {
+	tests := []string{
+		"magic",
+		"pcl(3)",
+		"min_size",
+		"stable()",
+		"min_size(-1)",
+		"pcl and",
+		"pcl or or pdf",
+	}
+
+	for _, expression := range tests {
+		t.Run(expression, func(t *testing.T) {
+			if _, err := NewJobValidator(expression); err == nil {
+				t.Errorf("got no error")
+			}
+		})
+	}
+}
+
+func TestSizeStable(t *testing.T) {
+	tests := []struct {
+		checks int
+		sizes  []int // size of the file at every validation, 0 keeps the previous size
+		want   []bool
+	}{
+		{1, []int{4, 0, 0}, []bool{false, true, false}},
+		{2, []int{4, 0, 0, 0}, []bool{false, false, true, false}},
+		{1, []int{4, 8, 0}, []bool{false, false, true}},
+		{2, []int{4, 0, 8, 0, 12, 0, 0}, []bool{false, false, false, false, false, false, true}},
+		{3, []int{4, 0, 0, 0}, []bool{false, false, false, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("stable(%d) %v", tt.checks, tt.sizes), func(t *testing.T) {
+			validator := SizeStable(tt.checks)
+			f := openJobFile(t, "")
+			for i, size := range tt.sizes {
+				if size > 0 {
+					if err := ioutil.WriteFile(f.Name(), []byte(strings.Repeat("x", size)), 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if got := validator(f); got != tt.want[i] {
+					t.Errorf("validation %d: got %v, want %v", i+1, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
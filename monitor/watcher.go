@@ -0,0 +1,185 @@
+package monitor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rjeczalik/notify"
+	log "github.com/sirupsen/logrus"
+)
+
+const DefaultPollInterval = 250 * time.Millisecond
+
+// Watcher reports writes to the files in the spool directory. It sends the path of every file
+// that has been written to on the channel passed to Watch until Stop is called.
+type Watcher interface {
+	Watch(path string, writes chan<- string) error
+	Stop()
+}
+
+// NewWatcher creates a watcher by name: "notify" uses the change notifications of the operating
+// system, "poll" compares the size and modification time of the files at the given interval.
+func NewWatcher(kind string, interval time.Duration) (Watcher, error) {
+	switch kind {
+	case "notify":
+		return NotifyWatcher(), nil
+	case "poll":
+		return PollWatcher(interval), nil
+	default:
+		return nil, fmt.Errorf("Unknown watcher: %s", kind)
+	}
+}
+
+type notifyWatcher struct {
+	events chan notify.EventInfo
+	done   chan struct{}
+}
+
+// NotifyWatcher returns a watcher based on the change notifications of the operating system.
+func NotifyWatcher() Watcher {
+	return &notifyWatcher{}
+}
+
+func (w *notifyWatcher) Watch(path string, writes chan<- string) error {
+	w.events = make(chan notify.EventInfo, 10)
+	w.done = make(chan struct{})
+	if err := notify.Watch(path, w.events, notify.Write); err != nil {
+		return err
+	}
+	go func() {
+		for {
+			select {
+			case ei := <-w.events:
+				if ei.Event() != notify.Write {
+					continue
+				}
+				select {
+				case writes <- ei.Path():
+				case <-w.done:
+					return
+				}
+			case <-w.done:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (w *notifyWatcher) Stop() {
+	notify.Stop(w.events)
+	close(w.done)
+}
+
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+type pollWatcher struct {
+	interval time.Duration
+	done     chan struct{}
+	stopped  sync.WaitGroup
+}
+
+// PollWatcher returns a watcher that scans the spool directory at the given interval. It works on
+// file systems that do not deliver change notifications reliably, like network shares. The
+// interval should be well below the timeouts of the queues.
+func PollWatcher(interval time.Duration) Watcher {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	return &pollWatcher{
+		interval: interval,
+	}
+}
+
+func (w *pollWatcher) scan(path string) (map[string]fileStamp, error) {
+	fis, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	stamps := make(map[string]fileStamp, len(fis))
+	for _, fi := range fis {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		stamps[fi.Name()] = fileStamp{size: fi.Size(), modTime: fi.ModTime()}
+	}
+	return stamps, nil
+}
+
+func (w *pollWatcher) Watch(path string, writes chan<- string) error {
+	previous, err := w.scan(path)
+	if err != nil {
+		return err
+	}
+	w.done = make(chan struct{})
+	w.stopped.Add(1)
+	go func() {
+		defer w.stopped.Done()
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+			}
+			current, err := w.scan(path)
+			if err != nil {
+				log.Errorf("Could not scan spool directory %s: %s", path, err)
+				continue
+			}
+			for name, stamp := range current {
+				if old, found := previous[name]; found && old == stamp {
+					continue
+				}
+				select {
+				case writes <- filepath.Join(path, name):
+				case <-w.done:
+					return
+				}
+			}
+			previous = current
+		}
+	}()
+	return nil
+}
+
+func (w *pollWatcher) Stop() {
+	close(w.done)
+	w.stopped.Wait()
+}
+
+// SetWatcher selects how the monitor detects writes to the spool directory. Without a watcher,
+// the monitor uses change notifications and falls back to polling if those are not available.
+// Must be called before Start.
+func (m *Monitor) SetWatcher(w Watcher) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	m.watcher = w
+}
+
+// watch starts the configured watcher or picks one automatically.
+func (m *Monitor) watch(writes chan<- string) (Watcher, error) {
+	m.m.Lock()
+	w := m.watcher
+	m.m.Unlock()
+
+	if w != nil {
+		return w, w.Watch(m.path, writes)
+	}
+
+	w = NotifyWatcher()
+	err := w.Watch(m.path, writes)
+	if err == nil {
+		return w, nil
+	}
+	log.Warnf("Change notifications not available for %s, polling instead: %s", m.path, err)
+	w = PollWatcher(DefaultPollInterval)
+	return w, w.Watch(m.path, writes)
+}
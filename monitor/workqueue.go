@@ -0,0 +1,363 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultQueueCapacity = 64
+	spillDir             = "overflow"
+)
+
+// OverflowPolicy determines what happens to a completed job when the work queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits until the consumer has taken enough jobs off the queue
+	OverflowBlock OverflowPolicy = iota
+	// OverflowSpill parks the job on disk and moves it into the queue once there is space
+	OverflowSpill
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowSpill:
+		return "spill"
+	default:
+		return fmt.Sprintf("UNKNOWN OVERFLOW POLICY: %d", p)
+	}
+}
+
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch s {
+	case "", "block":
+		return OverflowBlock, nil
+	case "spill":
+		return OverflowSpill, nil
+	default:
+		return OverflowBlock, fmt.Errorf("Unknown overflow policy: %s", s)
+	}
+}
+
+// QueuedJob describes a job that has been completed by its queue, but not yet finished by the consumer.
+type QueuedJob struct {
+	Name    string
+	Device  string
+	Time    time.Time
+	Title   string
+	Spilled bool // the job is parked on disk because the work queue was full
+	Active  bool // the job has been handed to the consumer
+	Held    bool // the job waits for an operator to release it
+}
+
+// workQueue sits between the queues and the consumer of Monitor.Jobs(). It keeps a FIFO per
+// device and hands out jobs round-robin across devices. A device only gets its next job handed
+// out once the consumer has marked the previous one as done or failed, which keeps the jobs
+// of a device in order even if the consumer processes jobs concurrently.
+type workQueue struct {
+	m        sync.Mutex
+	space    *sync.Cond
+	monitor  *Monitor
+	capacity int
+	overflow OverflowPolicy
+	devices  []string
+	rr       int
+	pending  map[string][]*Job
+	active   map[string]*Job
+	held     map[string]bool // queues whose jobs are parked until they are released
+	spilled  []string
+	count    int
+	closed   bool
+	wake     chan struct{}
+}
+
+func newWorkQueue(m *Monitor) *workQueue {
+	w := &workQueue{
+		monitor:  m,
+		capacity: DefaultQueueCapacity,
+		overflow: OverflowBlock,
+		pending:  make(map[string][]*Job),
+		active:   make(map[string]*Job),
+		held:     make(map[string]bool),
+		wake:     make(chan struct{}, 1),
+	}
+	w.space = sync.NewCond(&w.m)
+	return w
+}
+
+func (w *workQueue) spillPath() string {
+	return filepath.Join(w.monitor.path, spillDir)
+}
+
+// clearSpilled removes parked jobs from a previous run, the journal takes care of recovering them.
+func (w *workQueue) clearSpilled() error {
+	err := os.RemoveAll(w.spillPath())
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(w.spillPath(), 0755)
+}
+
+func (w *workQueue) signal() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// waiting returns the number of queued jobs that wait for the consumer. Held jobs do not count,
+// they would keep the jobs of all other devices out of the queue until they are released.
+func (w *workQueue) waiting() int {
+	n := w.count
+	for _, jobs := range w.pending {
+		for _, j := range jobs {
+			if j.held {
+				n--
+			}
+		}
+	}
+	return n
+}
+
+func (w *workQueue) full() bool {
+	// once jobs have been spilled, new jobs have to queue up behind them to maintain the order
+	return w.waiting() >= w.capacity || len(w.spilled) > 0
+}
+
+// put adds a completed job to the queue. Depending on the overflow policy it blocks or spills the
+// job to disk if the queue is full. Jobs added with force bypass the capacity check.
+func (w *workQueue) put(j *Job, force bool) error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	for !w.closed && !force && w.full() {
+		if w.overflow == OverflowSpill {
+			return w.spill(j)
+		}
+		log.Warnf("Work queue is full, waiting to submit job %s", j.Name)
+		w.space.Wait()
+	}
+
+	if w.closed {
+		return fmt.Errorf("Cannot submit job %s, work queue has been closed", j.Name)
+	}
+
+	// duplicates arrive already held
+	j.held = j.held || w.held[j.queueFile]
+	w.push(j)
+	log.Debugf("Submitted job %s to work queue", j.Name)
+	if j.held {
+		w.publishHeld(j)
+	}
+	return nil
+}
+
+func (w *workQueue) push(j *Job) {
+	if _, found := w.pending[j.queueFile]; !found {
+		w.devices = append(w.devices, j.queueFile)
+	}
+	w.pending[j.queueFile] = append(w.pending[j.queueFile], j)
+	w.count++
+	w.signal()
+}
+
+func (w *workQueue) spill(j *Job) error {
+	entry := j.journalEntry(JobSubmitted, nil)
+	// duplicates have to stay parked when they are loaded again, the hold of the queue is
+	// applied by refill
+	entry.Held = j.duplicate
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(w.spillPath(), j.Name+".json")
+	if err = ioutil.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	w.spilled = append(w.spilled, path)
+	log.Warnf("Work queue is full, parked job %s on disk", j.Name)
+	return nil
+}
+
+// refill moves spilled jobs back into memory while there is space.
+func (w *workQueue) refill() {
+	for len(w.spilled) > 0 && w.waiting() < w.capacity {
+		path := w.spilled[0]
+		w.spilled = w.spilled[1:]
+		j, err := w.loadSpilled(path)
+		if err != nil {
+			w.dropSpilled(path, err)
+			continue
+		}
+		os.Remove(path)
+		j.held = j.held || w.held[j.queueFile]
+		w.push(j)
+	}
+}
+
+// dropSpilled gives up on a parked job that cannot be loaded again.
+func (w *workQueue) dropSpilled(path string, err error) {
+	os.Remove(path)
+	entry := w.monitor.journal.entry(strings.TrimSuffix(filepath.Base(path), ".json"))
+	log.Errorf("Could not load parked job %s: %s", entry.Job, err)
+	entry.Time = time.Now()
+	entry.State = JobFailed
+	entry.Error = err.Error()
+	if err := w.monitor.journal.append(entry); err != nil {
+		log.Errorf("Could not update job journal: %s", err)
+	}
+	w.monitor.events.publish(Event{
+		Type:   JobDropped,
+		Device: entry.Device,
+		Queue:  entry.Queue,
+		Job:    entry.Job,
+		Err:    err,
+	})
+}
+
+func (w *workQueue) loadSpilled(path string) (*Job, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	entry := &journalEntry{}
+	if err = json.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+	return w.monitor.jobFromEntry(entry), nil
+}
+
+// next returns the next job to hand out or nil if there is none.
+func (w *workQueue) next() *Job {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	w.refill()
+
+	for i := range w.devices {
+		idx := (w.rr + i) % len(w.devices)
+		device := w.devices[idx]
+		if w.active[device] != nil {
+			continue
+		}
+		// held jobs stay in place, released ones may overtake them
+		pos := -1
+		for k, j := range w.pending[device] {
+			if !j.held {
+				pos = k
+				break
+			}
+		}
+		if pos < 0 {
+			continue
+		}
+		j := w.pending[device][pos]
+		w.pending[device] = append(w.pending[device][:pos], w.pending[device][pos+1:]...)
+		w.active[device] = j
+		w.count--
+		w.rr = idx + 1
+		w.space.Broadcast()
+		return j
+	}
+	return nil
+}
+
+// finish releases the device of a job so that its next job can be handed out.
+func (w *workQueue) finish(j *Job) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if w.active[j.queueFile] == j {
+		delete(w.active, j.queueFile)
+		w.signal()
+	}
+}
+
+func (w *workQueue) close() {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	w.closed = true
+	w.space.Broadcast()
+}
+
+// run hands out jobs to the consumer until the context is cancelled. Jobs that are still
+// queued at that point remain in the journal and are recovered on the next start.
+func (w *workQueue) run(ctx context.Context, out chan<- *Job) {
+	for {
+		j := w.next()
+		if j == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-w.wake:
+				continue
+			}
+		}
+		select {
+		case out <- j:
+			log.Debugf("Handed job %s to consumer", j.Name)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *workQueue) jobs() []QueuedJob {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	jobs := make([]QueuedJob, 0, w.count+len(w.active)+len(w.spilled))
+	for _, j := range w.active {
+		jobs = append(jobs, QueuedJob{Name: j.Name, Device: j.Device, Time: j.Time, Title: j.Title, Active: true})
+	}
+	for _, device := range w.devices {
+		for _, j := range w.pending[device] {
+			jobs = append(jobs, QueuedJob{Name: j.Name, Device: j.Device, Time: j.Time, Title: j.Title, Held: j.held})
+		}
+	}
+	for _, path := range w.spilled {
+		j, err := w.loadSpilled(path)
+		if err != nil {
+			continue
+		}
+		jobs = append(jobs, QueuedJob{Name: j.Name, Device: j.Device, Time: j.Time, Title: j.Title, Spilled: true, Held: j.held || w.held[j.queueFile]})
+	}
+	sort.SliceStable(jobs, func(i, k int) bool {
+		return jobs[i].Name < jobs[k].Name
+	})
+	return jobs
+}
+
+// SetQueueLimits configures the capacity of the work queue between the queues and the consumer
+// of Jobs() and what happens to completed jobs once it is full.
+func (m *Monitor) SetQueueLimits(capacity int, overflow OverflowPolicy) error {
+	if capacity < 1 {
+		return fmt.Errorf("Invalid work queue capacity: %d", capacity)
+	}
+	m.work.m.Lock()
+	defer m.work.m.Unlock()
+	m.work.capacity = capacity
+	m.work.overflow = overflow
+	m.work.space.Broadcast()
+	return nil
+}
+
+// QueuedJobs returns all jobs that have been completed by their queues, but not yet
+// marked as done or failed by the consumer.
+func (m *Monitor) QueuedJobs() []QueuedJob {
+	return m.work.jobs()
+}
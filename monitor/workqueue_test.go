@@ -0,0 +1,232 @@
+package monitor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// drain takes jobs off the work queue like a consumer that processes all jobs it can get
+// concurrently and finishes them together. Every round is separated by a |.
+func drain(w *workQueue) string {
+	rounds := make([]string, 0)
+	for {
+		round := make([]*Job, 0)
+		for j := w.next(); j != nil; j = w.next() {
+			round = append(round, j)
+		}
+		if len(round) == 0 {
+			return strings.Join(rounds, " | ")
+		}
+		names := make([]string, 0, len(round))
+		for _, j := range round {
+			names = append(names, j.Name)
+			w.finish(j)
+		}
+		rounds = append(rounds, strings.Join(names, " "))
+	}
+}
+
+func TestWorkQueueOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		overflow OverflowPolicy
+		held     []string // devices whose queues are held
+		jobs     []string // the first letter of a job is its device
+		want     string
+	}{
+		{
+			name:     "fifo per device",
+			capacity: 10,
+			jobs:     []string{"A1", "A2", "A3"},
+			want:     "A1 | A2 | A3",
+		},
+		{
+			name:     "round robin",
+			capacity: 10,
+			jobs:     []string{"A1", "A2", "A3", "B1", "C1", "C2"},
+			want:     "A1 B1 C1 | A2 C2 | A3",
+		},
+		{
+			name:     "held queue",
+			capacity: 10,
+			held:     []string{"B"},
+			jobs:     []string{"A1", "B1", "A2", "B2"},
+			want:     "A1 | A2",
+		},
+		{
+			name:     "spill keeps order",
+			capacity: 2,
+			overflow: OverflowSpill,
+			jobs:     []string{"A1", "A2", "A3", "B1", "A4", "B2"},
+			want:     "A1 | A2 B1 | A3 B2 | A4",
+		},
+		{
+			name:     "spill held queue",
+			capacity: 1,
+			overflow: OverflowSpill,
+			held:     []string{"B"},
+			jobs:     []string{"A1", "B1", "A2"},
+			want:     "A1 | A2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMonitor(t.TempDir(), nil)
+			w := m.work
+			if err := w.clearSpilled(); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.SetQueueLimits(tt.capacity, tt.overflow); err != nil {
+				t.Fatal(err)
+			}
+			for _, device := range tt.held {
+				w.held[device] = true
+			}
+			for _, name := range tt.jobs {
+				j := &Job{Name: name, Device: name[:1], queueFile: name[:1], monitor: m}
+				if err := w.put(j, false); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := drain(w); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkQueueSpilledJobs(t *testing.T) {
+	m := NewMonitor(t.TempDir(), nil)
+	w := m.work
+	if err := w.clearSpilled(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetQueueLimits(1, OverflowSpill); err != nil {
+		t.Fatal(err)
+	}
+
+	duplicate := &Job{Name: "A2", Device: "A", queueFile: "A", monitor: m, held: true, duplicate: true}
+	for _, j := range []*Job{{Name: "A1", Device: "A", queueFile: "A", monitor: m}, duplicate, {Name: "A3", Device: "A", queueFile: "A", monitor: m}} {
+		if err := w.put(j, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := make([]string, 0)
+	for _, j := range m.QueuedJobs() {
+		state := j.Name
+		if j.Spilled {
+			state += " spilled"
+		}
+		if j.Held {
+			state += " held"
+		}
+		got = append(got, state)
+	}
+	if want := "A1, A2 spilled held, A3 spilled"; strings.Join(got, ", ") != want {
+		t.Errorf("got %q, want %q", strings.Join(got, ", "), want)
+	}
+
+	// the duplicate stays parked after it has been loaded again
+	if got, want := drain(w), "A1 | A3"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestWorkQueueSpilledJobLost(t *testing.T) {
+	m := NewMonitor(t.TempDir(), nil)
+	w := m.work
+	if err := w.clearSpilled(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.journal.open(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer m.journal.close()
+	if err := m.SetQueueLimits(1, OverflowSpill); err != nil {
+		t.Fatal(err)
+	}
+	events := m.Subscribe()
+	defer events.Cancel()
+
+	for _, name := range []string{"A1", "A2", "A3"} {
+		j := &Job{Name: name, Device: "A", queueFile: "A", monitor: m}
+		m.journal.record(j, JobSubmitted, nil)
+		if err := w.put(j, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(w.spillPath(), "A2.json"), []byte("{\"job\":"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := drain(w), "A1 | A3"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if e := nextEvent(t, events, JobDropped); e.Job != "A2" || e.Device != "A" || e.Err == nil {
+		t.Errorf("got event %+v", e)
+	}
+	if entry := m.journal.entry("A2"); entry.State != JobFailed || entry.Error == "" {
+		t.Errorf("lost job is %s in the journal", entry.State)
+	}
+	if _, err := os.Stat(filepath.Join(w.spillPath(), "A2.json")); !os.IsNotExist(err) {
+		t.Errorf("parked job has not been removed: %v", err)
+	}
+}
+
+func TestWorkQueueBlockedSubmit(t *testing.T) {
+	dir := t.TempDir()
+	m := NewMonitor(dir, nil)
+	w := m.work
+	if err := m.journal.open(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer m.journal.close()
+	if err := m.SetQueueLimits(1, OverflowBlock); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.put(&Job{Name: "B1", Device: "B", queueFile: "B", monitor: m}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	q := &Queue{Device: "A", File: filepath.Join(dir, "a.txt"), monitor: m}
+	if err := ioutil.WriteFile(q.File, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	j := &Job{Name: "A1", Device: "A", File: filepath.Join(dir, "A1.prn"), queueFile: "a.txt", queue: q, monitor: m}
+	q.job = j
+	events := m.Subscribe()
+	defer events.Cancel()
+	submitted := make(chan error, 1)
+	go func() {
+		submitted <- j.trySubmit(false)
+	}()
+	nextEvent(t, events, JobCompleted)
+
+	// the job must not stay locked while it waits for space in the work queue
+	locked := make(chan struct{})
+	go func() {
+		j.m.Lock()
+		j.m.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("job is locked while waiting for the work queue")
+	}
+
+	w.finish(w.next())
+	if err := <-submitted; err != nil {
+		t.Fatal(err)
+	}
+	if got := drain(w); got != "A1" {
+		t.Errorf("got %q, want A1", got)
+	}
+}
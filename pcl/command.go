@@ -0,0 +1,104 @@
+package pcl
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const esc = 0x1b
+
+// Command is a single command of a parameterized escape sequence. Commands that were combined
+// into one sequence, like ESC&l1o2S, share the parameter and group character.
+type Command struct {
+	Parameter  byte   // the character after ESC, like & or *
+	Group      byte   // the lowercase group character, 0 if the sequence has none
+	Value      string // the value field as it appeared in the data, may be empty
+	Terminator byte   // always uppercase, even within combined sequences
+	Data       []byte // binary data that follows the command, like raster rows or font headers
+}
+
+// Name identifies the kind of command independent of its value, like "&lO" for the page orientation.
+func (c Command) Name() string {
+	if c.Group == 0 {
+		return string([]byte{c.Parameter, c.Terminator})
+	}
+	return string([]byte{c.Parameter, c.Group, c.Terminator})
+}
+
+// Is reports whether the command has the given name.
+func (c Command) Is(name string) bool {
+	return c.Name() == name
+}
+
+// Float returns the value of the command, an empty value is 0.
+func (c Command) Float() float64 {
+	v, _ := strconv.ParseFloat(c.Value, 64)
+	return v
+}
+
+// Int returns the integer part of the value of the command.
+func (c Command) Int() int {
+	value := c.Value
+	if idx := strings.IndexByte(value, '.'); idx >= 0 {
+		value = value[:idx]
+	}
+	v, _ := strconv.Atoi(strings.TrimPrefix(value, "+"))
+	return v
+}
+
+// hasData reports whether binary data follows the command. The value is the number of bytes.
+func (c Command) hasData() bool {
+	switch c.Terminator {
+	case 'W':
+		return true
+	case 'V':
+		// raster plane
+		return c.Parameter == '*' && c.Group == 'b'
+	case 'X':
+		// transparent print data
+		return c.Parameter == '&' && c.Group == 'p'
+	default:
+		return false
+	}
+}
+
+// Bytes encodes the command as a standalone escape sequence.
+func (c Command) Bytes() []byte {
+	return encodeCommands([]Command{c})
+}
+
+func (c Command) String() string {
+	return fmt.Sprintf("ESC%c%s", c.Parameter, c.sequence())
+}
+
+// sequence is the readable form of the command without the leading ESC and parameter character.
+func (c Command) sequence() string {
+	if c.Group == 0 {
+		return c.Value + string(c.Terminator)
+	}
+	return string(c.Group) + c.Value + string(c.Terminator)
+}
+
+// encodeCommands writes commands that share parameter and group character as a combined sequence.
+func encodeCommands(commands []Command) []byte {
+	var buf bytes.Buffer
+	for i, c := range commands {
+		if i == 0 {
+			buf.WriteByte(esc)
+			buf.WriteByte(c.Parameter)
+			if c.Group != 0 {
+				buf.WriteByte(c.Group)
+			}
+		}
+		buf.WriteString(c.Value)
+		if i < len(commands)-1 {
+			buf.WriteByte(c.Terminator | 0x20)
+		} else {
+			buf.WriteByte(c.Terminator)
+		}
+		buf.Write(c.Data)
+	}
+	return buf.Bytes()
+}
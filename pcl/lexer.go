@@ -0,0 +1,264 @@
+// Package pcl tokenizes PCL5 print data into text and typed escape sequences, so that print jobs
+// can be inspected and modified based on their commands instead of text patterns.
+package pcl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ErrTruncated is reported if the data ends within an escape sequence or its binary data.
+var ErrTruncated = errors.New("Print data ends within an escape sequence")
+
+var (
+	uec      = []byte("\x1b%-12345X")
+	pjlStart = []byte("@PJL")
+	pjlEnter = []byte("ENTER LANGUAGE")
+)
+
+type TokenType int
+
+const (
+	// Text is printable data and control characters like line feeds
+	Text TokenType = iota
+	// Control is a two-character escape sequence like ESC E
+	Control
+	// Sequence is a parameterized escape sequence with one or more commands
+	Sequence
+	// UEC is the universal exit language command that separates PJL jobs
+	UEC
+	// PJL is a single PJL command line including its line ending, or whitespace between PJL commands
+	PJL
+)
+
+func (t TokenType) String() string {
+	switch t {
+	case Text:
+		return "text"
+	case Control:
+		return "control"
+	case Sequence:
+		return "sequence"
+	case UEC:
+		return "uec"
+	case PJL:
+		return "pjl"
+	default:
+		return fmt.Sprintf("UNKNOWN TOKEN TYPE: %d", t)
+	}
+}
+
+// Token is a piece of print data.
+type Token struct {
+	Type     TokenType
+	Offset   int       // position of the token in the data
+	Raw      []byte    // the token as it appeared in the data
+	Control  byte      // the character after ESC of a Control token
+	Commands []Command // the commands of a Sequence token
+}
+
+// Is reports whether the token is the two-character escape sequence ESC c.
+func (t Token) Is(c byte) bool {
+	return t.Type == Control && t.Control == c
+}
+
+// Bytes encodes the token. Sequences are encoded from their commands, so that changes to the
+// commands are reflected, and a sequence without commands disappears.
+func (t Token) Bytes() []byte {
+	if t.Type != Sequence {
+		return t.Raw
+	}
+	if len(t.Commands) == 0 {
+		return nil
+	}
+	return encodeCommands(t.Commands)
+}
+
+// Lexer splits print data into tokens. PJL commands are recognized after a UEC until the PJL
+// job switches to a printer language.
+type Lexer struct {
+	data  []byte
+	pos   int
+	pjl   bool
+	token Token
+	err   error
+}
+
+func NewLexer(data []byte) *Lexer {
+	return &Lexer{data: data}
+}
+
+// Scan advances to the next token, it returns false at the end of the data.
+func (l *Lexer) Scan() bool {
+	if l.pos >= len(l.data) {
+		return false
+	}
+	start := l.pos
+	l.token = Token{Offset: start}
+
+	switch {
+	case bytes.HasPrefix(l.data[start:], uec):
+		l.pjl = true
+		l.token.Type = UEC
+		l.pos += len(uec)
+	case l.pjl && bytes.HasPrefix(l.data[start:], pjlStart):
+		l.token.Type = PJL
+		end := bytes.IndexByte(l.data[start:], '\n')
+		if end < 0 {
+			l.pos = len(l.data)
+		} else {
+			l.pos += end + 1
+		}
+		if bytes.Contains(bytes.ToUpper(l.data[start:l.pos]), pjlEnter) {
+			l.pjl = false
+		}
+	case l.pjl && isSpace(l.data[start]):
+		// line endings and padding between PJL commands
+		l.token.Type = PJL
+		for l.pos < len(l.data) && isSpace(l.data[l.pos]) {
+			l.pos++
+		}
+	case l.data[start] == esc:
+		// anything but PJL switches the printer to a printer language
+		l.pjl = false
+		l.scanEscape()
+	default:
+		l.pjl = false
+		l.token.Type = Text
+		end := bytes.IndexByte(l.data[start:], esc)
+		if end < 0 {
+			l.pos = len(l.data)
+		} else {
+			l.pos += end
+		}
+	}
+
+	l.token.Raw = l.data[start:l.pos]
+	return true
+}
+
+func (l *Lexer) scanEscape() {
+	data := l.data
+	i := l.pos + 1
+	if i >= len(data) {
+		l.truncated()
+		return
+	}
+
+	if data[i] < '!' || data[i] > '/' {
+		l.token.Type = Control
+		l.token.Control = data[i]
+		l.pos = i + 1
+		return
+	}
+
+	parameter := data[i]
+	i++
+	var group byte
+	if i < len(data) && isLower(data[i]) {
+		group = data[i]
+		i++
+	}
+
+	commands := make([]Command, 0, 1)
+	for {
+		valueStart := i
+		for i < len(data) && isValue(data[i]) {
+			i++
+		}
+		if i >= len(data) {
+			l.truncated()
+			return
+		}
+		c := Command{
+			Parameter: parameter,
+			Group:     group,
+			Value:     string(data[valueStart:i]),
+		}
+		last := true
+		switch {
+		case isUpper(data[i]):
+			c.Terminator = data[i]
+		case isLower(data[i]):
+			c.Terminator = data[i] &^ 0x20
+			last = false
+		default:
+			// not a valid escape sequence, printers ignore what has been read so far
+			l.token.Type = Text
+			l.pos = i
+			return
+		}
+		i++
+		if c.hasData() {
+			n := c.Int()
+			if n < 0 || i+n > len(data) {
+				l.truncated()
+				return
+			}
+			c.Data = data[i : i+n]
+			i += n
+		}
+		commands = append(commands, c)
+		if last {
+			break
+		}
+	}
+
+	l.token.Type = Sequence
+	l.token.Commands = commands
+	l.pos = i
+}
+
+// truncated turns the rest of the data into a text token.
+func (l *Lexer) truncated() {
+	l.err = ErrTruncated
+	l.token.Type = Text
+	l.pos = len(l.data)
+}
+
+// Token returns the token found by the last call to Scan.
+func (l *Lexer) Token() Token {
+	return l.token
+}
+
+// Err returns ErrTruncated if the data ended within an escape sequence. The incomplete
+// sequence is returned as a Text token.
+func (l *Lexer) Err() error {
+	return l.err
+}
+
+// Tokenize splits the data into tokens.
+func Tokenize(data []byte) ([]Token, error) {
+	tokens := make([]Token, 0)
+	l := NewLexer(data)
+	for l.Scan() {
+		tokens = append(tokens, l.Token())
+	}
+	return tokens, l.Err()
+}
+
+// Encode turns tokens back into print data.
+func Encode(tokens []Token) []byte {
+	var buf bytes.Buffer
+	for _, t := range tokens {
+		buf.Write(t.Bytes())
+	}
+	return buf.Bytes()
+}
+
+func isUpper(c byte) bool {
+	return c >= '@' && c <= '^'
+}
+
+func isLower(c byte) bool {
+	return c >= '`' && c <= '~'
+}
+
+func isValue(c byte) bool {
+	return c >= '0' && c <= '9' || c == '+' || c == '-' || c == '.'
+}
+
+func isSpace(c byte) bool {
+	return c == '\r' || c == '\n' || c == ' ' || c == '\t'
+}
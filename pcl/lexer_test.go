@@ -0,0 +1,230 @@
+package pcl
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// describe summarizes tokens like "text:abc control:E sequence:&lO=1,&lS=2".
+func describe(tokens []Token) string {
+	parts := make([]string, 0, len(tokens))
+	for _, t := range tokens {
+		switch t.Type {
+		case Control:
+			parts = append(parts, fmt.Sprintf("control:%c", t.Control))
+		case Sequence:
+			commands := make([]string, 0, len(t.Commands))
+			for _, c := range t.Commands {
+				command := c.Name() + "=" + c.Value
+				if len(c.Data) > 0 {
+					command += fmt.Sprintf("[%q]", c.Data)
+				}
+				commands = append(commands, command)
+			}
+			parts = append(parts, "sequence:"+strings.Join(commands, ","))
+		default:
+			parts = append(parts, fmt.Sprintf("%s:%q", t.Type, t.Raw))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+		err  error
+	}{
+		{
+			name: "text",
+			data: "Hello\r\n",
+			want: `text:"Hello\r\n"`,
+		},
+		{
+			name: "reset and text",
+			data: "\x1bEHello\f",
+			want: `control:E text:"Hello\f"`,
+		},
+		{
+			name: "combined sequence",
+			data: "\x1b&l1o2a8D",
+			want: "sequence:&lO=1,&lA=2,&lD=8",
+		},
+		{
+			name: "sequence without group",
+			data: "\x1b(10U",
+			want: "sequence:(U=10",
+		},
+		{
+			name: "signed and decimal values",
+			data: "\x1b*p+120x-3.5Y",
+			want: "sequence:*pX=+120,*pY=-3.5",
+		},
+		{
+			name: "raster data",
+			data: "\x1b*b3WA\x1bZtext",
+			want: `sequence:*bW=3["A\x1bZ"] text:"text"`,
+		},
+		{
+			name: "transparent print data",
+			data: "\x1b&p2X\x1bE",
+			want: `sequence:&pX=2["\x1bE"]`,
+		},
+		{
+			name: "pjl header",
+			data: "\x1b%-12345X@PJL JOB NAME=\"a\"\r\n@PJL ENTER LANGUAGE=PCL\r\n\x1bE",
+			want: `uec:"\x1b%-12345X" pjl:"@PJL JOB NAME=\"a\"\r\n" pjl:"@PJL ENTER LANGUAGE=PCL\r\n" control:E`,
+		},
+		{
+			name: "whitespace between pjl commands",
+			data: "\x1b%-12345X\r\n@PJL\r\n \x1b%-12345X",
+			want: `uec:"\x1b%-12345X" pjl:"\r\n" pjl:"@PJL\r\n" pjl:" " uec:"\x1b%-12345X"`,
+		},
+		{
+			name: "text ends pjl",
+			data: "\x1b%-12345X@PJL\r\nHello @PJL",
+			want: `uec:"\x1b%-12345X" pjl:"@PJL\r\n" text:"Hello @PJL"`,
+		},
+		{
+			name: "invalid sequence",
+			data: "\x1b&l1!x",
+			want: `text:"\x1b&l1" text:"!x"`,
+		},
+		{
+			name: "truncated sequence",
+			data: "abc\x1b&l1",
+			want: `text:"abc" text:"\x1b&l1"`,
+			err:  ErrTruncated,
+		},
+		{
+			name: "truncated data",
+			data: "\x1b*b5WAB",
+			want: `text:"\x1b*b5WAB"`,
+			err:  ErrTruncated,
+		},
+		{
+			name: "lone escape",
+			data: "\x1b",
+			want: `text:"\x1b"`,
+			err:  ErrTruncated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := Tokenize([]byte(tt.data))
+			if err != tt.err {
+				t.Errorf("got error %v, want %v", err, tt.err)
+			}
+			if got := describe(tokens); got != tt.want {
+				t.Errorf("got tokens\n%s\nwant\n%s", got, tt.want)
+			}
+			offset := 0
+			for _, token := range tokens {
+				if token.Offset != offset {
+					t.Errorf("token %s at offset %d, want %d", describe([]Token{token}), token.Offset, offset)
+				}
+				offset += len(token.Raw)
+			}
+			if got := Encode(tokens); !bytes.Equal(got, []byte(tt.data)) {
+				t.Errorf("encoding does not reproduce the data: got %q", got)
+			}
+		})
+	}
+}
+
+func TestEncodeModified(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		modify func(c []Command) []Command
+		want   string
+	}{
+		{
+			name: "change value",
+			data: "\x1b&l1O",
+			modify: func(c []Command) []Command {
+				c[0].Value = "0"
+				return c
+			},
+			want: "\x1b&l0O",
+		},
+		{
+			name: "drop first command of combined sequence",
+			data: "\x1b&l1o26A",
+			modify: func(c []Command) []Command {
+				return c[1:]
+			},
+			want: "\x1b&l26A",
+		},
+		{
+			name: "drop last command of combined sequence",
+			data: "\x1b&l1o26A",
+			modify: func(c []Command) []Command {
+				return c[:1]
+			},
+			want: "\x1b&l1O",
+		},
+		{
+			name: "drop all commands",
+			data: "a\x1b&l1o26Ab",
+			modify: func(c []Command) []Command {
+				return nil
+			},
+			want: "ab",
+		},
+		{
+			name: "keep data",
+			data: "\x1b*b2w1Y\x01\x02",
+			modify: func(c []Command) []Command {
+				return c
+			},
+			want: "\x1b*b2w1Y\x01\x02",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := Tokenize([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range tokens {
+				if tokens[i].Type == Sequence {
+					tokens[i].Commands = tt.modify(tokens[i].Commands)
+				}
+			}
+			if got := string(Encode(tokens)); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandValues(t *testing.T) {
+	tests := []struct {
+		value string
+		int   int
+		float float64
+	}{
+		{"", 0, 0},
+		{"12", 12, 12},
+		{"+12", 12, 12},
+		{"-3", -3, -3},
+		{"7.5", 7, 7.5},
+		{"+.5", 0, 0.5},
+	}
+
+	for _, tt := range tests {
+		c := Command{Parameter: '&', Group: 'l', Value: tt.value, Terminator: 'D'}
+		if got := c.Int(); got != tt.int {
+			t.Errorf("Int() of %q = %d, want %d", tt.value, got, tt.int)
+		}
+		if got := c.Float(); got != tt.float {
+			t.Errorf("Float() of %q = %g, want %g", tt.value, got, tt.float)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package pcl
+
+// vertical distances are in 1/48 inch, the unit of the VMI command
+const (
+	inch       = 48
+	defaultVMI = inch / 6
+)
+
+// page sizes for ESC&l#A in inches, portrait height and landscape height
+var pageHeights = map[int][2]float64{
+	1:  {10.5, 7.25},   // executive
+	2:  {11, 8.5},      // letter
+	3:  {14, 8.5},      // legal
+	26: {11.69, 8.27},  // A4
+	27: {16.54, 11.69}, // A3
+}
+
+const defaultPageSize = 26
+
+// pageCounter follows the commands that end a page closely enough to count the pages of a job.
+type pageCounter struct {
+	pages      int
+	marked     bool // something was put on the current page
+	size       int
+	landscape  bool
+	vmi        float64
+	textLength float64 // height of the text area
+	line       float64 // vertical position within the text area
+	perfSkip   bool
+}
+
+func newPageCounter() *pageCounter {
+	c := &pageCounter{}
+	c.reset()
+	return c
+}
+
+// reset restores the defaults of the printer, like ESC E does.
+func (c *pageCounter) reset() {
+	c.size = defaultPageSize
+	c.landscape = false
+	c.vmi = defaultVMI
+	c.perfSkip = true
+	c.resetTextArea()
+}
+
+// resetTextArea sets the text area to the page minus the default margins of half an inch.
+func (c *pageCounter) resetTextArea() {
+	height := pageHeights[c.size][0]
+	if c.landscape {
+		height = pageHeights[c.size][1]
+	}
+	c.textLength = (height - 1) * inch
+	c.line = 0
+}
+
+// eject ends the current page, empty pages do not come out of the printer.
+func (c *pageCounter) eject() {
+	if c.marked {
+		c.pages++
+	}
+	c.marked = false
+	c.line = 0
+}
+
+func (c *pageCounter) lineFeed() {
+	c.line += c.vmi
+	// with perforation skip, moving below the text area starts a new page
+	if c.perfSkip && c.line+c.vmi > c.textLength+0.01 {
+		c.eject()
+	}
+}
+
+func (c *pageCounter) text(data []byte) {
+	for _, b := range data {
+		switch {
+		case b == '\n':
+			c.lineFeed()
+		case b == '\f':
+			c.eject()
+		case b > ' ':
+			c.marked = true
+		}
+	}
+}
+
+func (c *pageCounter) command(cmd Command) {
+	switch cmd.Name() {
+	case "&lH":
+		// page eject and paper source selection
+		c.eject()
+	case "&lA":
+		c.eject()
+		if _, known := pageHeights[cmd.Int()]; known {
+			c.size = cmd.Int()
+		}
+		c.resetTextArea()
+	case "&lO":
+		c.eject()
+		c.landscape = cmd.Int()%2 == 1
+		c.resetTextArea()
+	case "&lC":
+		if vmi := cmd.Float(); vmi > 0 {
+			c.vmi = vmi
+		}
+	case "&lD":
+		if lpi := cmd.Float(); lpi > 0 {
+			c.vmi = inch / lpi
+		}
+	case "&lF":
+		if lines := cmd.Int(); lines > 0 {
+			c.textLength = float64(lines) * c.vmi
+		}
+	case "&lL":
+		c.perfSkip = cmd.Int() != 0
+	case "*bW", "*bV":
+		c.marked = c.marked || len(cmd.Data) > 0
+	case "*cP":
+		// rectangular area fill
+		c.marked = true
+	}
+}
+
+func (c *pageCounter) token(t Token) {
+	switch t.Type {
+	case Text:
+		c.text(t.Raw)
+	case Control:
+		if t.Control == 'E' {
+			c.eject()
+			c.reset()
+		}
+	case Sequence:
+		for _, cmd := range t.Commands {
+			c.command(cmd)
+		}
+	case UEC:
+		// the printer finishes the page when it leaves PCL
+		c.eject()
+		c.reset()
+	}
+}
+
+// CountPages returns the number of pages the printer puts out for the tokens. Pages end at form
+// feeds, page ejects, resets, and when the text runs past the bottom of the text area, which
+// depends on the page size, the orientation, the text length and the line spacing (VMI).
+// Blank pages are not counted.
+func CountPages(tokens []Token) int {
+	c := newPageCounter()
+	for _, t := range tokens {
+		c.token(t)
+	}
+	c.eject()
+	return c.pages
+}
@@ -0,0 +1,56 @@
+package pcl
+
+import (
+	"strings"
+	"testing"
+)
+
+// lines returns n lines of text.
+func lines(n int) string {
+	return strings.Repeat("text\r\n", n)
+}
+
+func TestCountPages(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		pages int
+	}{
+		{"empty", "", 0},
+		{"blank", "\x1bE\r\n\r\n\x1bE", 0},
+		{"single line", "\x1bEHello", 1},
+		{"form feeds", "one\fTWO\fthree", 3},
+		{"trailing form feed", "one\ftwo\f", 2},
+		{"blank page between form feeds", "one\f\ftwo\f", 2},
+		{"page eject", "one\x1b&l0Htwo", 2},
+		{"paper source selection ejects", "one\x1b&l4Htwo\x1b&l1H", 2},
+		{"reset", "one\x1bEtwo\x1bE", 2},
+		{"uec", "one\x1b%-12345X@PJL\r\n\x1b%-12345Xtwo", 2},
+		{"full A4 page", lines(64), 1},
+		{"overflowing A4 page", lines(65), 2},
+		{"letter", "\x1b&l2A" + lines(61), 2},
+		{"landscape", "\x1b&l1O" + lines(43), 1},
+		{"landscape overflow", "\x1b&l1O" + lines(44), 2},
+		{"8 lines per inch", "\x1b&l8D" + lines(85), 1},
+		{"8 lines per inch overflow", "\x1b&l8D" + lines(86), 2},
+		{"vmi", "\x1b&l6C" + lines(86), 2},
+		{"text length", "\x1b&l66F" + lines(66), 1},
+		{"no perforation skip", "\x1b&l0L" + lines(200), 1},
+		{"orientation change ejects", "one\x1b&l1Otwo", 2},
+		{"raster graphics", "\x1b*b2W\x00\xff\f", 1},
+		{"empty raster row", "\x1b*b0W\f", 0},
+		{"rectangle", "\x1b*c0P", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := Tokenize([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := CountPages(tokens); got != tt.pages {
+				t.Errorf("got %d pages, want %d", got, tt.pages)
+			}
+		})
+	}
+}
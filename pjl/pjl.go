@@ -0,0 +1,361 @@
+// Package pjl parses and builds the PJL commands that HP printers expect in front of a print job.
+package pjl
+
+import (
+	"bytes"
+	"strings"
+)
+
+const (
+	// UEC is the universal exit language command that starts and ends every PJL job.
+	UEC = "\x1b%-12345X"
+	// Newline terminates every PJL command.
+	Newline = "\r\n"
+	prefix  = "@PJL"
+)
+
+// command names
+const (
+	Job     = "JOB"
+	EOJ     = "EOJ"
+	Set     = "SET"
+	Default = "DEFAULT"
+	Enter   = "ENTER"
+	Comment = "COMMENT"
+	Reset   = "RESET"
+)
+
+// Option is a single option of a command, like NAME = "Invoice" or DUPLEX = ON. Options like
+// the ID of INFO ID have no value.
+type Option struct {
+	Name   string
+	Value  string
+	Quoted bool // the value is a string, not an enumerated value or a number
+}
+
+// Command is a single @PJL line.
+type Command struct {
+	Name     string // uppercase command name like JOB or SET, empty for a bare @PJL line
+	Modifier string // command modifier like LPARM : PCL
+	Options  []Option
+	Text     string // the remark of a COMMENT
+}
+
+// Option returns the value of the option with the given name.
+func (c Command) Option(name string) (string, bool) {
+	for _, o := range c.Options {
+		if strings.EqualFold(o.Name, name) {
+			return o.Value, true
+		}
+	}
+	return "", false
+}
+
+// Variable is the name of the variable changed by a SET or DEFAULT command.
+func (c Command) Variable() string {
+	if (c.Name != Set && c.Name != Default) || len(c.Options) == 0 {
+		return ""
+	}
+	return c.Options[0].Name
+}
+
+// overrides reports whether c replaces o when both are part of a header.
+func (c Command) overrides(o Command) bool {
+	switch c.Name {
+	case Job, EOJ, Enter:
+		return c.Name == o.Name
+	case Set, Default:
+		return c.Name == o.Name && c.Modifier == o.Modifier && c.Variable() == o.Variable()
+	default:
+		return false
+	}
+}
+
+// String returns the command without its line ending.
+func (c Command) String() string {
+	var b strings.Builder
+	b.WriteString(prefix)
+	if c.Name == "" {
+		return b.String()
+	}
+	b.WriteString(" ")
+	b.WriteString(c.Name)
+	if c.Name == Comment {
+		if c.Text != "" {
+			b.WriteString(" ")
+			b.WriteString(clean(c.Text))
+		}
+		return b.String()
+	}
+	if c.Modifier != "" {
+		b.WriteString(" ")
+		b.WriteString(c.Modifier)
+	}
+	for _, o := range c.Options {
+		b.WriteString(" ")
+		b.WriteString(o.Name)
+		if o.Value == "" && !o.Quoted {
+			continue
+		}
+		b.WriteString(" = ")
+		if o.Quoted {
+			b.WriteString(Quote(o.Value))
+		} else {
+			b.WriteString(o.Value)
+		}
+	}
+	return b.String()
+}
+
+// Quote turns s into a PJL string. PJL has no escape mechanism, so double quotes are replaced
+// by single quotes and line breaks and other control characters are dropped.
+func Quote(s string) string {
+	return `"` + strings.ReplaceAll(clean(s), `"`, "'") + `"`
+}
+
+// clean removes the characters that would end a command.
+func clean(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' && r != '\t' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// NewJob starts a job with the name shown in the printer's job log and the text shown on its display.
+func NewJob(name, display string) Command {
+	c := Command{Name: Job, Options: []Option{{Name: "NAME", Value: name, Quoted: true}}}
+	if display != "" {
+		c.Options = append(c.Options, Option{Name: "DISPLAY", Value: display, Quoted: true})
+	}
+	return c
+}
+
+// NewEOJ ends the job with the given name.
+func NewEOJ(name string) Command {
+	return Command{Name: EOJ, Options: []Option{{Name: "NAME", Value: name, Quoted: true}}}
+}
+
+// NewSet changes a setting for the current job.
+func NewSet(variable, value string) Command {
+	return Command{Name: Set, Options: []Option{{Name: strings.ToUpper(variable), Value: value}}}
+}
+
+// NewEnterLanguage switches the printer to the language of the print data.
+func NewEnterLanguage(language string) Command {
+	return Command{Name: Enter, Options: []Option{{Name: "LANGUAGE", Value: strings.ToUpper(language)}}}
+}
+
+// NewComment adds a remark that the printer ignores.
+func NewComment(text string) Command {
+	return Command{Name: Comment, Text: text}
+}
+
+// ParseCommand parses a single @PJL line. It returns false if the line is not a PJL command.
+func ParseCommand(line []byte) (Command, bool) {
+	s := strings.TrimRight(string(line), "\r\n")
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return Command{}, false
+	}
+	s = strings.TrimLeft(s[len(prefix):], " \t")
+
+	var c Command
+	words := split(s)
+	if len(words) == 0 {
+		return c, true
+	}
+	c.Name = strings.ToUpper(words[0].text)
+	if c.Name == Comment {
+		c.Text = strings.TrimLeft(s[len(Comment):], " \t")
+		return c, true
+	}
+	words = words[1:]
+	if len(words) >= 3 && words[1].text == ":" && !words[1].quoted {
+		c.Modifier = strings.ToUpper(words[0].text) + " : " + strings.ToUpper(words[2].text)
+		words = words[3:]
+	}
+	for len(words) > 0 {
+		o := Option{Name: strings.ToUpper(words[0].text)}
+		words = words[1:]
+		if len(words) >= 2 && words[0].text == "=" && !words[0].quoted {
+			o.Value = words[1].text
+			o.Quoted = words[1].quoted
+			words = words[2:]
+		}
+		c.Options = append(c.Options, o)
+	}
+	return c, true
+}
+
+type word struct {
+	text   string
+	quoted bool
+}
+
+// split cuts the arguments of a command into words, quoted strings and the separators = and :.
+func split(s string) []word {
+	words := make([]word, 0)
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '=' || c == ':':
+			words = append(words, word{text: s[i : i+1]})
+			i++
+		case c == '"':
+			end := strings.IndexByte(s[i+1:], '"')
+			if end < 0 {
+				end = len(s) - i - 1
+			}
+			words = append(words, word{text: s[i+1 : i+1+end], quoted: true})
+			i += end + 2
+		default:
+			start := i
+			for i < len(s) && !strings.ContainsRune(" \t=:\"", rune(s[i])) {
+				i++
+			}
+			words = append(words, word{text: s[start:i]})
+		}
+	}
+	return words
+}
+
+// Header is the sequence of PJL commands in front of the print data.
+type Header []Command
+
+// Parse reads the PJL header at the start of data, i.e. the commands after the initial UEC up to
+// ENTER LANGUAGE or the first line that is not a PJL command. It also returns the length of the
+// header in bytes. Data that does not start with a UEC has no header.
+func Parse(data []byte) (Header, int) {
+	if !bytes.HasPrefix(data, []byte(UEC)) {
+		return nil, 0
+	}
+	h := make(Header, 0)
+	pos := len(UEC)
+	for pos < len(data) {
+		rest := data[pos:]
+		if trimmed := bytes.TrimLeft(rest, " \t\r\n"); len(trimmed) < len(rest) {
+			pos += len(rest) - len(trimmed)
+			continue
+		}
+		end := bytes.IndexByte(rest, '\n')
+		if end < 0 {
+			end = len(rest)
+		} else {
+			end++
+		}
+		c, ok := ParseCommand(rest[:end])
+		if !ok {
+			break
+		}
+		pos += end
+		h = append(h, c)
+		if c.Name == Enter {
+			break
+		}
+	}
+	return h, pos
+}
+
+// Find returns the first command with the given name.
+func (h Header) Find(name string) (Command, bool) {
+	for _, c := range h {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Command{}, false
+}
+
+// JobName returns the name of the job, or an empty string if the header does not start a named job.
+func (h Header) JobName() string {
+	c, _ := h.Find(Job)
+	name, _ := c.Option("NAME")
+	return name
+}
+
+// Language returns the language the header switches to.
+func (h Header) Language() string {
+	c, _ := h.Find(Enter)
+	language, _ := c.Option("LANGUAGE")
+	return language
+}
+
+// Get returns the value of a variable set with SET.
+func (h Header) Get(variable string) (string, bool) {
+	for i := len(h) - 1; i >= 0; i-- {
+		if h[i].Name == Set && h[i].Modifier == "" && strings.EqualFold(h[i].Variable(), variable) {
+			return h[i].Options[0].Value, true
+		}
+	}
+	return "", false
+}
+
+// Filter returns the commands with the given names.
+func (h Header) Filter(names ...string) Header {
+	filtered := make(Header, 0, len(h))
+	for _, c := range h {
+		for _, name := range names {
+			if c.Name == name {
+				filtered = append(filtered, c)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// Merge combines the header with the commands of other, which override the job, language and
+// settings of h. The result starts with the job and ends with the language switch.
+func (h Header) Merge(other Header) Header {
+	merged := make(Header, 0, len(h)+len(other))
+	var job, enter *Command
+	add := func(c Command) {
+		switch c.Name {
+		case "":
+			return
+		case Job:
+			job = &c
+			return
+		case Enter:
+			enter = &c
+			return
+		}
+		for i := range merged {
+			if c.overrides(merged[i]) {
+				merged[i] = c
+				return
+			}
+		}
+		merged = append(merged, c)
+	}
+	for _, c := range h {
+		add(c)
+	}
+	for _, c := range other {
+		add(c)
+	}
+	if job != nil {
+		merged = append(Header{*job}, merged...)
+	}
+	if enter != nil {
+		merged = append(merged, *enter)
+	}
+	return merged
+}
+
+// Bytes serializes the header, starting with a UEC and a bare @PJL line.
+func (h Header) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(UEC)
+	buf.WriteString(prefix + Newline)
+	for _, c := range h {
+		if c.Name == "" {
+			continue
+		}
+		buf.WriteString(c.String())
+		buf.WriteString(Newline)
+	}
+	return buf.Bytes()
+}
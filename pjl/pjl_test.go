@@ -0,0 +1,262 @@
+package pjl
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		line string
+		want Command
+		ok   bool
+	}{
+		{
+			line: "@PJL\r\n",
+			want: Command{},
+			ok:   true,
+		},
+		{
+			line: `@PJL JOB NAME = "Invoice 42" DISPLAY="Printing"` + "\r\n",
+			want: Command{Name: Job, Options: []Option{
+				{Name: "NAME", Value: "Invoice 42", Quoted: true},
+				{Name: "DISPLAY", Value: "Printing", Quoted: true},
+			}},
+			ok: true,
+		},
+		{
+			line: "@pjl set duplex=on\n",
+			want: Command{Name: Set, Options: []Option{{Name: "DUPLEX", Value: "on"}}},
+			ok:   true,
+		},
+		{
+			line: "@PJL SET LPARM : PCL SYMSET = ROMAN8",
+			want: Command{Name: Set, Modifier: "LPARM : PCL", Options: []Option{{Name: "SYMSET", Value: "ROMAN8"}}},
+			ok:   true,
+		},
+		{
+			line: "@PJL INFO ID",
+			want: Command{Name: "INFO", Options: []Option{{Name: "ID"}}},
+			ok:   true,
+		},
+		{
+			line: "@PJL COMMENT  keep = this: as is\r\n",
+			want: Command{Name: Comment, Text: "keep = this: as is"},
+			ok:   true,
+		},
+		{
+			line: `@PJL JOB NAME = "unterminated`,
+			want: Command{Name: Job, Options: []Option{{Name: "NAME", Value: "unterminated", Quoted: true}}},
+			ok:   true,
+		},
+		{
+			line: "PJL JOB",
+			ok:   false,
+		},
+		{
+			line: "@PJ",
+			ok:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, ok := ParseCommand([]byte(tt.line))
+			if ok != tt.ok {
+				t.Fatalf("got ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"Invoice", `"Invoice"`},
+		{"", `""`},
+		{`Say "hi"`, `"Say 'hi'"`},
+		{"two\r\nlines", `"twolines"`},
+		{"tab\tand\x1bescape\x7f", "\"tab\tandescape\""},
+		{"Grüße", `"Grüße"`},
+	}
+
+	for _, tt := range tests {
+		if got := Quote(tt.s); got != tt.want {
+			t.Errorf("Quote(%q) = %s, want %s", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestCommandString(t *testing.T) {
+	tests := []struct {
+		command Command
+		want    string
+	}{
+		{Command{}, "@PJL"},
+		{NewJob("a\"b", ""), `@PJL JOB NAME = "a'b"`},
+		{NewJob("job", "Printing"), `@PJL JOB NAME = "job" DISPLAY = "Printing"`},
+		{NewEOJ("job"), `@PJL EOJ NAME = "job"`},
+		{NewSet("duplex", "ON"), "@PJL SET DUPLEX = ON"},
+		{NewEnterLanguage("pcl"), "@PJL ENTER LANGUAGE = PCL"},
+		{NewComment("line\r\nbreak"), "@PJL COMMENT linebreak"},
+		{Command{Name: "INFO", Options: []Option{{Name: "ID"}}}, "@PJL INFO ID"},
+		{Command{Name: Set, Modifier: "LPARM : PCL", Options: []Option{{Name: "SYMSET", Value: "ROMAN8"}}}, "@PJL SET LPARM : PCL SYMSET = ROMAN8"},
+	}
+
+	for _, tt := range tests {
+		got := tt.command.String()
+		if got != tt.want {
+			t.Errorf("got %s, want %s", got, tt.want)
+		}
+		// everything we write must read back the same
+		parsed, ok := ParseCommand([]byte(got))
+		if !ok || parsed.String() != got {
+			t.Errorf("%s does not survive parsing, got %s", got, parsed.String())
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		commands []string
+		length   int
+	}{
+		{
+			name: "no uec",
+			data: "@PJL JOB\r\n",
+		},
+		{
+			name:     "header with language switch",
+			data:     UEC + "@PJL\r\n@PJL JOB NAME = \"a\"\r\n@PJL ENTER LANGUAGE = PCL\r\n\x1bE",
+			commands: []string{"@PJL", `@PJL JOB NAME = "a"`, "@PJL ENTER LANGUAGE = PCL"},
+			length:   len(UEC) + 6 + 21 + 27,
+		},
+		{
+			name:     "header ends with data",
+			data:     UEC + "\r\n@PJL SET COPIES = 2\r\n\x1bE",
+			commands: []string{"@PJL SET COPIES = 2"},
+			length:   len(UEC) + 2 + 21,
+		},
+		{
+			name:     "empty header",
+			data:     UEC + "\x1bE",
+			commands: []string{},
+			length:   len(UEC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, n := Parse([]byte(tt.data))
+			if n != tt.length {
+				t.Errorf("got length %d, want %d", n, tt.length)
+			}
+			if tt.commands == nil {
+				if h != nil {
+					t.Errorf("got header %v, want none", h)
+				}
+				return
+			}
+			got := make([]string, 0, len(h))
+			for _, c := range h {
+				got = append(got, c.String())
+			}
+			if !reflect.DeepEqual(got, tt.commands) {
+				t.Errorf("got %q, want %q", got, tt.commands)
+			}
+		})
+	}
+}
+
+func TestHeaderQueries(t *testing.T) {
+	h, _ := Parse([]byte(UEC + "@PJL JOB NAME = \"Invoice\"\r\n@PJL SET DUPLEX = OFF\r\n@PJL SET LPARM : PCL DUPLEX = X\r\n@PJL SET duplex = ON\r\n@PJL ENTER LANGUAGE = POSTSCRIPT\r\n"))
+
+	if got := h.JobName(); got != "Invoice" {
+		t.Errorf("JobName() = %q", got)
+	}
+	if got := h.Language(); got != "POSTSCRIPT" {
+		t.Errorf("Language() = %q", got)
+	}
+	if got, ok := h.Get("duplex"); !ok || got != "ON" {
+		t.Errorf("Get(duplex) = %q, %v", got, ok)
+	}
+	if _, ok := h.Get("COPIES"); ok {
+		t.Errorf("Get(COPIES) found a value")
+	}
+	if got := len(h.Filter(Set)); got != 3 {
+		t.Errorf("Filter(SET) returned %d commands", got)
+	}
+	if got := (Header{}).JobName(); got != "" {
+		t.Errorf("JobName() of empty header = %q", got)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name  string
+		h     Header
+		other Header
+		want  []string
+	}{
+		{
+			name:  "job first and language last",
+			h:     Header{NewSet("COPIES", "2"), NewEnterLanguage("PCL"), NewJob("theirs", "")},
+			other: Header{NewSet("DUPLEX", "ON")},
+			want:  []string{`@PJL JOB NAME = "theirs"`, "@PJL SET COPIES = 2", "@PJL SET DUPLEX = ON", "@PJL ENTER LANGUAGE = PCL"},
+		},
+		{
+			name:  "other overrides job, language and settings",
+			h:     Header{NewJob("theirs", ""), NewSet("DUPLEX", "OFF"), NewSet("COPIES", "2"), NewEnterLanguage("PCL")},
+			other: Header{NewJob("ours", "Printing"), NewSet("DUPLEX", "ON"), NewEnterLanguage("POSTSCRIPT")},
+			want:  []string{`@PJL JOB NAME = "ours" DISPLAY = "Printing"`, "@PJL SET DUPLEX = ON", "@PJL SET COPIES = 2", "@PJL ENTER LANGUAGE = POSTSCRIPT"},
+		},
+		{
+			name:  "modifiers and defaults are separate variables",
+			h:     Header{NewSet("DUPLEX", "OFF"), {Name: Default, Options: []Option{{Name: "DUPLEX", Value: "OFF"}}}},
+			other: Header{{Name: Set, Modifier: "LPARM : PCL", Options: []Option{{Name: "DUPLEX", Value: "ON"}}}},
+			want:  []string{"@PJL SET DUPLEX = OFF", "@PJL DEFAULT DUPLEX = OFF", "@PJL SET LPARM : PCL DUPLEX = ON"},
+		},
+		{
+			name:  "comments are kept and bare lines dropped",
+			h:     Header{{}, NewComment("a"), NewComment("a")},
+			other: Header{{}, NewComment("b")},
+			want:  []string{"@PJL COMMENT a", "@PJL COMMENT a", "@PJL COMMENT b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged := tt.h.Merge(tt.other)
+			got := make([]string, 0, len(merged))
+			for _, c := range merged {
+				got = append(got, c.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestBytes(t *testing.T) {
+	h := Header{{}, NewJob("a", ""), NewEnterLanguage("PCL")}
+	want := UEC + "@PJL\r\n@PJL JOB NAME = \"a\"\r\n@PJL ENTER LANGUAGE = PCL\r\n"
+	if got := string(h.Bytes()); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	parsed, n := Parse(h.Bytes())
+	if n != len(want) || len(parsed) != 3 {
+		t.Errorf("serialized header does not parse back: %v, %d", parsed, n)
+	}
+}
@@ -0,0 +1,66 @@
+package printing
+
+import (
+	"fmt"
+
+	"github.com/smuething/devicemonitor/app"
+	"github.com/smuething/devicemonitor/classify"
+)
+
+var yes = true
+
+// defaultClassificationRules are used if the configuration has no global rules: text placed at
+// absolute positions belongs to a form, everything else is a list.
+var defaultClassificationRules = []classify.Rule{
+	{Name: "absolute positioning", Type: JobTypeForm.String(), AbsolutePositioning: &yes},
+}
+
+func classificationRules(config []app.ClassificationRule) []classify.Rule {
+	rules := make([]classify.Rule, 0, len(config))
+	for _, r := range config {
+		rules = append(rules, classify.Rule{
+			Name:                r.Name,
+			Type:                r.Type,
+			AbsolutePositioning: r.AbsolutePositioning,
+			Landscape:           r.Landscape,
+			Macros:              r.Macros,
+			Graphics:            r.Graphics,
+			MinLineWidth:        r.MinLineWidth,
+			MaxLineWidth:        r.MaxLineWidth,
+			PageSizes:           r.PageSizes,
+			Pattern:             r.Pattern,
+		})
+	}
+	return rules
+}
+
+// newClassifier combines the classification rules of a device with the global ones.
+func newClassifier(global app.ClassificationConfig, device *app.ClassificationConfig) (*classify.Classifier, error) {
+	rules := make([]classify.Rule, 0)
+	defaultType := global.Default
+	if device != nil {
+		rules = append(rules, classificationRules(device.Rules)...)
+		if device.Default != "" {
+			defaultType = device.Default
+		}
+	}
+	if len(global.Rules) > 0 {
+		rules = append(rules, classificationRules(global.Rules)...)
+	} else {
+		rules = append(rules, defaultClassificationRules...)
+	}
+	if defaultType == "" {
+		defaultType = JobTypeList.String()
+	}
+
+	classifier, err := classify.New(rules, defaultType)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range classifier.Types() {
+		if jobType, err := ParseJobTypeString(t); err != nil || jobType == invalidJobType {
+			return nil, fmt.Errorf("Unknown job type in classification rules: %s", t)
+		}
+	}
+	return classifier, nil
+}
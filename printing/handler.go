@@ -7,7 +7,6 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
 	"strings"
 
 	"github.com/alexbrainman/printer"
@@ -15,10 +14,6 @@ import (
 	"github.com/smuething/devicemonitor/monitor"
 )
 
-var (
-	pitchRE *regexp.Regexp = regexp.MustCompile("\x1b\\(s([1-9][0-9]?(\\.[0-9]+))H")
-)
-
 func pclPitch(pitch int) string {
 	return fmt.Sprintf("\x1b(s%dH", pitch)
 }
@@ -28,7 +23,6 @@ type Job struct {
 	hasPJL         bool
 	hasMultipleUEC bool
 	landscape      bool
-	pitchLoc       []int
 	pdf            string
 }
 
@@ -65,23 +59,9 @@ func (j *Job) parse() error {
 
 	//j.landscape = strings.Index(data, pclLandscape) >= 0
 
-	indices := pitchRE.FindStringSubmatchIndex(data)
-	if len(indices) > 0 {
-		j.pitchLoc = indices[2:4]
-	}
-
 	return nil
 }
 
-func Foo(monitor *monitor.Monitor) {
-	for mj := range monitor.Jobs() {
-		j := newJob(mj)
-		j.parse()
-		j.createPDF(`w:\`)
-		j.showPDF()
-	}
-}
-
 func (j *Job) createPDF(path string) {
 
 	j.pdf = j.Time.Format("Printout 2006-01-02 150405.pdf")
@@ -0,0 +1,39 @@
+package printing
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/smuething/devicemonitor/pjl"
+)
+
+// ctrlD ends PostScript jobs that were sent over serial and parallel lines.
+const ctrlD = "\x04"
+
+// detectLanguage determines whether captured print data is PostScript or PCL. A PJL header that
+// switches the language decides, otherwise the start of the data.
+func detectLanguage(data []byte) PrintLanguage {
+	header, n := pjl.Parse(data)
+	switch strings.ToUpper(header.Language()) {
+	case "POSTSCRIPT":
+		return PrintLanguagePostScript
+	case "PCL":
+		return PrintLanguagePCL
+	}
+	if bytes.HasPrefix(bytes.TrimLeft(data[n:], " \t\r\n"+ctrlD), []byte("%!")) {
+		return PrintLanguagePostScript
+	}
+	return PrintLanguagePCL
+}
+
+// postScriptProgram strips the PJL commands around a PostScript program and the Ctrl-D that
+// terminates it. The program ends with a line break.
+func postScriptProgram(data []byte) []byte {
+	_, n := pjl.Parse(data)
+	data = data[n:]
+	if end := bytes.Index(data, []byte(pjl.UEC)); end >= 0 {
+		data = data[:end]
+	}
+	program := bytes.Trim(data, " \t\r\n"+ctrlD)
+	return append(program[:len(program):len(program)], '\n')
+}
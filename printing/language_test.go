@@ -0,0 +1,53 @@
+package printing
+
+import "testing"
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want PrintLanguage
+	}{
+		{"postscript", "%!PS-Adobe-3.0\n%%Pages: 1\nshowpage\n", PrintLanguagePostScript},
+		{"postscript after ctrl-d", ctrlD + "\r\n%!PS\nshowpage\n", PrintLanguagePostScript},
+		{"pjl enter postscript", uec + "@PJL JOB\r\n@PJL ENTER LANGUAGE = POSTSCRIPT\r\n%!PS\nshowpage\n", PrintLanguagePostScript},
+		{"pjl prefix", uec + "@PJL\r\n@PJL SET COPIES=1\r\n%!PS\nshowpage\n", PrintLanguagePostScript},
+		{"pjl enter pcl", uec + "@PJL ENTER LANGUAGE=PCL\r\n%!not PostScript", PrintLanguagePCL},
+		{"pcl", "\x1bE%!\x1bE", PrintLanguagePCL},
+		{"pjl pcl", uec + "@PJL JOB\r\n\x1bEdata\x1bE" + uec, PrintLanguagePCL},
+		{"text", "Summe %!\r\n", PrintLanguagePCL},
+		{"empty", "", PrintLanguagePCL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectLanguage([]byte(tt.data)); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPostScriptProgram(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"plain", "%!PS\nshowpage", "%!PS\nshowpage\n"},
+		{"ctrl-d", ctrlD + "%!PS\nshowpage\n" + ctrlD, "%!PS\nshowpage\n"},
+		{
+			name: "pjl",
+			data: uec + "@PJL JOB\r\n@PJL ENTER LANGUAGE=POSTSCRIPT\r\n%!PS\r\nshowpage\r\n" + ctrlD + uec + "@PJL EOJ\r\n" + uec,
+			want: "%!PS\r\nshowpage\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(postScriptProgram([]byte(tt.data))); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
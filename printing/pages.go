@@ -0,0 +1,35 @@
+package printing
+
+import (
+	"regexp"
+	"strconv"
+)
+
+var (
+	pdfPage      = regexp.MustCompile(`/Type\s*/Page\b`)
+	pdfPageCount = regexp.MustCompile(`/Type\s*/Pages\b[^>]*?/Count\s+(\d+)|/Count\s+(\d+)[^>]*?/Type\s*/Pages\b`)
+	psPage       = regexp.MustCompile(`(?m)^%%Page:`)
+)
+
+// countPDFPages counts the page objects of a PDF file. If the pages are hidden in compressed
+// object streams, it falls back to the largest page count of the page tree.
+func countPDFPages(data []byte) int {
+	if pages := len(pdfPage.FindAllIndex(data, -1)); pages > 0 {
+		return pages
+	}
+	pages := 0
+	for _, match := range pdfPageCount.FindAllSubmatch(data, -1) {
+		for _, count := range match[1:] {
+			if n, err := strconv.Atoi(string(count)); err == nil && n > pages {
+				pages = n
+			}
+		}
+	}
+	return pages
+}
+
+// countPostScriptPages counts the pages of a PostScript program that follows the document
+// structuring conventions. Programs without DSC comments have 0 pages.
+func countPostScriptPages(data []byte) int {
+	return len(psPage.FindAllIndex(data, -1))
+}
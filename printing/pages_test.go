@@ -0,0 +1,56 @@
+package printing
+
+import "testing"
+
+func TestCountPDFPages(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		pages int
+	}{
+		{"no pages", "%PDF-1.4\n%%EOF", 0},
+		{
+			name:  "page objects",
+			data:  "1 0 obj << /Type /Pages /Kids [2 0 R 3 0 R] /Count 2 >> endobj 2 0 obj << /Type /Page >> endobj 3 0 obj <</Type/Page/Parent 1 0 R>> endobj",
+			pages: 2,
+		},
+		{
+			name:  "compressed page objects",
+			data:  "1 0 obj << /Type /Pages /Count 3 /Kids [4 0 R] >> endobj 5 0 obj << /Count 12 /Type /Pages >> endobj",
+			pages: 12,
+		},
+		{
+			name:  "outlines do not count",
+			data:  "1 0 obj << /Type /Outlines /Count 7 >> endobj",
+			pages: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := countPDFPages([]byte(tt.data)); got != tt.pages {
+				t.Errorf("got %d pages, want %d", got, tt.pages)
+			}
+		})
+	}
+}
+
+func TestCountPostScriptPages(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		pages int
+	}{
+		{"no dsc", "%!PS\nshowpage\n", 0},
+		{"pages", "%!PS-Adobe-3.0\n%%Pages: 2\n%%Page: 1 1\nshowpage\n%%Page: 2 2\nshowpage\n%%EOF\n", 2},
+		{"comment within a line", "%!PS\n(%%Page: 1 1) show\n", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := countPostScriptPages([]byte(tt.data)); got != tt.pages {
+				t.Errorf("got %d pages, want %d", got, tt.pages)
+			}
+		})
+	}
+}
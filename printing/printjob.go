@@ -2,16 +2,20 @@ package printing
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
 	"strings"
 
 	"github.com/smuething/devicemonitor/app"
+	"github.com/smuething/devicemonitor/classify"
+	"github.com/smuething/devicemonitor/pcl"
+	"github.com/smuething/devicemonitor/pjl"
 
+	"github.com/alexbrainman/printer"
 	log "github.com/sirupsen/logrus"
 	"github.com/smuething/devicemonitor/monitor"
 )
@@ -45,19 +49,11 @@ const (
 )
 
 const (
-	newline            = "\r\n"
-	newlineRE          = "\r?\n"
 	uec                = "\x1b%-12345X"
 	uecPJL             = uec + "@PJL"
 	pjlLandscapePrefix = "\x1b%-12345X@PJL DEFAULT SETDISTILLERPARAMS = \"<< /AutoRotatePages /All >>\"\r"
 	MaxJobSize         = 8 * (1 << 20) // 8 MiB should be plenty
-)
-
-var (
-	pclSimplexDuplex    = regexp.MustCompile("\x1b&l(0|1|2)S" + newlineRE)
-	pclLandscape        = regexp.MustCompile("\x1b&l1O" + newlineRE)
-	pclAbsolutePosition = regexp.MustCompile("\x1b*p([1-9][0-9]*(\\.?[0-9]*))x([1-9][0-9]*(\\.?[0-9]*))Y" + newlineRE)
-	pjlBlock            = regexp.MustCompile(uecPJL + ".*?ENTER LANGUAGE\\s*=\\s*PCL\\s*" + newlineRE)
+	defaultPDFDir      = "w:\\"
 )
 
 type PrintJob struct {
@@ -65,51 +61,146 @@ type PrintJob struct {
 	Name        string
 	Title       string
 	Language    PrintLanguage
+	Target      string // the printer that prints the job, or app.TargetPDF to only create a PDF file
+	ViaPDF      bool   // convert the job to PDF and print that instead of the captured data
+	JobConfig   string
 	Duplex      bool
-	Tray        int
+	Color       bool
+	PaperTray   string // PJL media source, the printer picks the tray if empty
 	JobType     JobType
 	Orientation Orientation
-	data        string
-	pdf         string
-	ghostPCL    string
-	ghostScript string
+	Pages       int // number of pages, 0 if they could not be counted
+	// split the captured data into several jobs at PJL job boundaries, and additionally at printer resets
+	SplitJobs    bool
+	SplitAtReset bool
+	file         string // the data of this job if it was split off a larger capture
+	part         int
+	data         string
+	tokens       []pcl.Token
+	header       pjl.Header // the PJL header of the captured data
+	pdf          string
+	pdfDir       string
+	postScript   bool // the target printer accepts PostScript
+	classifier   *classify.Classifier
+	ghostPCL     string
+	ghostScript  string
 }
 
-func NewPrintJob(job *monitor.Job, name string, title string, language PrintLanguage, duplex bool, tray int) PrintJob {
+// NewPrintJob creates the print job for a captured job from the current settings of its device:
+// the selected target, the job config selected for that target and whether to print via PDF.
+func NewPrintJob(job *monitor.Job) (*PrintJob, error) {
 	config := app.Config()
 	config.Lock()
 	defer config.Unlock()
 
-	return PrintJob{
-		Job:         job,
-		Name:        name,
-		Title:       title,
-		Language:    language,
-		Duplex:      duplex,
-		Tray:        tray,
-		ghostPCL:    config.Paths.GhostPCL,
-		ghostScript: config.Paths.GhostScript,
+	dc, found := config.Devices[strings.ToLower(job.Device)]
+	if !found {
+		return nil, fmt.Errorf("No configuration for device %s", job.Device)
+	}
+
+	j := &PrintJob{
+		Job:          job,
+		Name:         job.Name,
+		Title:        job.Title,
+		Language:     PrintLanguagePCL,
+		Target:       dc.Target,
+		ViaPDF:       dc.PrintViaPDF,
+		SplitJobs:    dc.SplitJobs,
+		SplitAtReset: dc.SplitAtReset,
+		pdfDir:       config.Paths.PDFDir,
+		ghostPCL:     config.Paths.GhostPCL,
+		ghostScript:  config.Paths.GhostScript,
+	}
+	if j.Title == "" {
+		j.Title = job.Name
+	}
+	if j.pdfDir == "" {
+		j.pdfDir = defaultPDFDir
+	}
+	classifier, err := newClassifier(config.Classification, dc.Classification)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid classification settings for device %s: %s", job.Device, err)
+	}
+	j.classifier = classifier
+
+	if j.Target == app.TargetChoose {
+		// there is nobody to ask while the job is processed in the background, and printing
+		// on a printer the user did not pick is worse than not printing at all
+		return nil, fmt.Errorf("Cannot ask for the printer of job %s, select a printer for device %s", job.Name, job.Device)
+	}
+	if j.Target == "" {
+		target, err := printer.Default()
+		if err != nil {
+			return nil, fmt.Errorf("Could not determine default printer for job %s: %s", job.Name, err)
+		}
+		if target == "" {
+			return nil, fmt.Errorf("No target selected for device %s and no default printer", job.Device)
+		}
+		j.Target = target
+	}
+	if j.Target == app.TargetPDF {
+		return j, nil
+	}
+
+	if pc := config.Printer(j.Target); pc != nil {
+		j.postScript = pc.PostScript
+		if jc, found := pc.Job(dc.JobConfigs[strings.ToLower(j.Target)]); found {
+			log.Debugf("Using job config %s for job %s on printer %s", jc.Name, job.Name, j.Target)
+			j.JobConfig = jc.Name
+			j.Duplex = jc.Duplex
+			j.Color = jc.Color
+			j.PaperTray = jc.PaperTrayPJLCode
+		}
 	}
+	return j, nil
+}
+
+// input returns the file that contains the data of the job.
+func (j *PrintJob) input() string {
+	if j.file != "" {
+		return j.file
+	}
+	return j.File
 }
 
 func (j *PrintJob) inspect() error {
 
-	fi, err := os.Stat(j.File)
+	fi, err := os.Stat(j.input())
 	if err != nil {
 		return err
 	}
 
 	if fi.Size() > MaxJobSize {
-		return fmt.Errorf("Could not process print job %s: file size %d exceeds max job size %d", j.File, fi.Size(), MaxJobSize)
+		return fmt.Errorf("Could not process print job %s: file size %d exceeds max job size %d", j.input(), fi.Size(), MaxJobSize)
 	}
 
-	rawData, err := ioutil.ReadFile(j.File)
+	rawData, err := ioutil.ReadFile(j.input())
 	if err != nil {
 		return err
 	}
 	j.data = string(rawData)
 
-	if pclLandscape.FindStringIndex(j.data) != nil {
+	j.Language = detectLanguage(rawData)
+	if j.Language == PrintLanguagePostScript {
+		// PostScript documents are laid out by the application and never scaled
+		j.header, _ = pjl.Parse(rawData)
+		j.Pages = countPostScriptPages(rawData)
+		j.JobType = JobTypeForm
+		log.Debugf("Job %s is a PostScript job with %d pages", j.input(), j.Pages)
+		return nil
+	}
+
+	tokens, err := pcl.Tokenize(rawData)
+	if err != nil {
+		log.Warnf("Job %s: %s", j.input(), err)
+	}
+	j.tokens = tokens
+	j.header, _ = pjl.Parse(rawData)
+	j.Pages = pcl.CountPages(tokens)
+	log.Debugf("Job %s has %d pages", j.input(), j.Pages)
+
+	features := classify.Extract(tokens)
+	if features.Landscape {
 		log.Debugf("Found landscape orientation command, assuming landscape orientation")
 		j.Orientation = OrientationLandscape
 	} else {
@@ -117,14 +208,18 @@ func (j *PrintJob) inspect() error {
 		j.Orientation = OrientationPortrait
 	}
 
-	if pclAbsolutePosition.FindStringIndex(j.data) != nil {
-		if j.Orientation == OrientationLandscape {
-			return fmt.Errorf("Found absolute positioning and landscape orientation in job %s, bailing out", j.File)
-		} else {
-			j.JobType = JobTypeList
+	if features.AbsolutePositioning && j.Orientation == OrientationLandscape {
+		return fmt.Errorf("Found absolute positioning and landscape orientation in job %s, bailing out", j.input())
+	}
+
+	j.JobType = JobTypeList
+	if j.classifier != nil {
+		jobType, rule := j.classifier.Classify(features)
+		j.JobType, _ = ParseJobTypeString(jobType)
+		if rule == "" {
+			rule = "default"
 		}
-	} else {
-		j.JobType = JobTypeList
+		log.Debugf("Job %s is a %s (%s)", j.input(), j.JobType, rule)
 	}
 
 	return nil
@@ -135,22 +230,72 @@ func (j *PrintJob) NeedsScaling() bool {
 }
 
 func (j *PrintJob) sanitize() error {
-	if j.Orientation == OrientationLandscape {
-		j.data = pclLandscape.ReplaceAllString(j.data, "")
+	if j.Language == PrintLanguagePostScript {
+		j.data = string(postScriptProgram([]byte(j.data)))
+		return nil
 	}
-	// remove simplex and duplex commands
-	j.data = pclSimplexDuplex.ReplaceAllString(j.data, "")
 
-	// remove all PJL commands
-	j.data = pjlBlock.ReplaceAllString(j.data, "")
+	tokens := make([]pcl.Token, 0, len(j.tokens))
+	dropNewline := false
+	for _, t := range j.tokens {
+		switch t.Type {
+		case pcl.UEC, pcl.PJL:
+			// remove all PJL commands
+			continue
+		case pcl.Sequence:
+			commands := make([]pcl.Command, 0, len(t.Commands))
+			for _, c := range t.Commands {
+				// remove simplex and duplex commands
+				if c.Is("&lS") || j.Orientation == OrientationLandscape && c.Is("&lO") {
+					continue
+				}
+				commands = append(commands, c)
+			}
+			// drivers put the removed commands on lines of their own
+			dropNewline = len(commands) == 0
+			t.Commands = commands
+			tokens = append(tokens, t)
+			continue
+		case pcl.Text:
+			if dropNewline {
+				t.Raw = bytes.TrimPrefix(t.Raw, []byte("\n"))
+				t.Raw = bytes.TrimPrefix(t.Raw, []byte("\r\n"))
+			}
+		}
+		dropNewline = false
+		tokens = append(tokens, t)
+	}
+	j.tokens = tokens
+	j.data = string(pcl.Encode(tokens))
 	return nil
 }
 
+// SanitizeForComparison strips the PJL commands from print data. Drivers put job names and
+// timestamps in there, which would make otherwise identical jobs look different.
+func SanitizeForComparison(data []byte) []byte {
+	tokens, _ := pcl.Tokenize(data)
+	sanitized := make([]byte, 0, len(data))
+	for _, t := range tokens {
+		if t.Type != pcl.UEC && t.Type != pcl.PJL {
+			sanitized = append(sanitized, t.Raw...)
+		}
+	}
+	return sanitized
+}
+
 func (j *PrintJob) createPDF(path string) error {
 
-	basename := strings.TrimSuffix(j.File, filepath.Ext(j.File))
+	basename := strings.TrimSuffix(j.input(), filepath.Ext(j.input()))
 
 	sanitizedName := basename + "-sanitized.txt"
+	converter := j.ghostPCL
+	if j.Language == PrintLanguagePostScript {
+		sanitizedName = basename + "-sanitized.ps"
+		converter = j.ghostScript
+	}
+	if converter == "" {
+		return fmt.Errorf("No converter configured for %s jobs", j.Language)
+	}
 	err := func() error {
 		sanitized, err := os.Create(sanitizedName)
 		if err != nil {
@@ -169,7 +314,11 @@ func (j *PrintJob) createPDF(path string) error {
 		return err
 	}
 
-	j.pdf = filepath.Join(path, j.Time.Format("Printout 2006-01-02 150405.pdf"))
+	pdf := j.Time.Format("Printout 2006-01-02 150405")
+	if j.part > 0 {
+		pdf = fmt.Sprintf("%s (%d)", pdf, j.part)
+	}
+	j.pdf = filepath.Join(path, pdf+".pdf")
 	log.Infof("Creating PDF file: %s", j.pdf)
 
 	scalePDF := j.NeedsScaling()
@@ -191,7 +340,7 @@ func (j *PrintJob) createPDF(path string) error {
 		sanitizedName,
 	}
 
-	cmd := exec.Command(j.ghostPCL, args...)
+	cmd := exec.Command(converter, args...)
 	//cmd.Stdout = os.Stdout //j.logfile
 	//cmd.Stderr = os.Stdout //j.logfile
 
@@ -267,116 +416,171 @@ func (j *PrintJob) createPDF(path string) error {
 	}
 
 	j.data = string(pdfBuf)
+	if pages := countPDFPages(pdfBuf); pages > 0 {
+		j.Pages = pages
+	}
 
 	return nil
 }
 
-func (j *PrintJob) spool(out *bufio.Writer) error {
-	write := func(format string, data ...interface{}) {
-		fmt.Fprintf(out, format, data...)
-		out.WriteString(newline)
-	}
-	writeRaw := func(format string, data ...interface{}) {
-		fmt.Fprintf(out, format, data...)
+// pjlLanguage is the name of the language for PJL ENTER LANGUAGE.
+func (l PrintLanguage) pjlLanguage() string {
+	switch l {
+	case PrintLanguagePCL:
+		return "PCL"
+	case PrintLanguagePDF:
+		return "PDF"
+	case PrintLanguagePostScript:
+		return "POSTSCRIPT"
+	default:
+		return ""
 	}
+}
 
-	write(uecPJL)
-	write(`@PJL JOB NAME = "%s" DISPLAY = "%s"`, j.Name, j.Title)
-
-	if j.Tray > 0 {
-		log.Debugf("Printing from tray %s", j.Tray)
-		write(`@PJL SET MEDIASOURCE = TRAY%d`, j.Tray)
+func (j *PrintJob) spool(out *bufio.Writer) error {
+	if j.Language.pjlLanguage() == "" {
+		return fmt.Errorf("Unknown job type: %d (%s)", j.Language, j.Language)
 	}
 
+	// our settings override the ones the application put into the captured job
+	header := pjl.Header{pjl.NewJob(j.Name, j.Title)}
+	if j.PaperTray != "" {
+		log.Debugf("Printing from tray %s", j.PaperTray)
+		header = append(header, pjl.NewSet("MEDIASOURCE", j.PaperTray))
+	}
 	if j.Duplex {
-		write(`@PJL SET DUPLEX = ON`)
+		header = append(header, pjl.NewSet("DUPLEX", "ON"))
 	} else {
-		write(`@PJL SET DUPLEX = OFF`)
+		header = append(header, pjl.NewSet("DUPLEX", "OFF"))
+	}
+	if j.Color {
+		header = append(header, pjl.NewSet("RENDERMODE", "COLOR"))
+	} else {
+		header = append(header, pjl.NewSet("RENDERMODE", "GRAYSCALE"))
 	}
-
 	if j.Orientation != invalidOrientation {
-		write(`@PJL SET ORIENTATION = %s`, j.Orientation)
+		header = append(header, pjl.NewSet("ORIENTATION", strings.ToUpper(j.Orientation.String())))
 	}
+	header = append(header, pjl.NewEnterLanguage(j.Language.pjlLanguage()))
+	header = j.header.Filter(pjl.Set, pjl.Comment).Merge(header)
 
-	write(`@PJL ENTER LANGUAGE = %s`, j.Language)
+	if _, err := out.Write(header.Bytes()); err != nil {
+		return err
+	}
 
 	switch j.Language {
 	case PrintLanguagePDF:
 		log.Debug("PDF job: forwarding PDF payload unchanged")
-		if _, err := out.WriteString(j.data); err != nil {
-			return err
-		}
 	case PrintLanguagePCL:
 		log.Debug("PCL job")
-		if _, err := out.WriteString(j.data); err != nil {
-			return err
-		}
-	default:
-		return fmt.Errorf("Unknown job type: %d (%s)", j.Language, j.Language)
+	case PrintLanguagePostScript:
+		log.Debug("PostScript job: forwarding PostScript program")
+	}
+	if _, err := out.WriteString(j.data); err != nil {
+		return err
 	}
 
-	write(uecPJL)
-	write(`@PJL RESET`)
-	write(`@PJL EOJ NAME = "%s"`, j.Name)
-	writeRaw(uec)
-	return nil
+	trailer := pjl.Header{{Name: pjl.Reset}, pjl.NewEOJ(j.Name)}
+	if _, err := out.Write(trailer.Bytes()); err != nil {
+		return err
+	}
+	_, err := out.WriteString(pjl.UEC)
+	return err
 }
 
-func (j *PrintJob) sendToPrinter() error {
-
-	var err error
+func (j *PrintJob) sendToPrinter() (err error) {
 	defer func() {
 		if err != nil {
-			log.Error(fmt.Errorf("Error forwarding data stream to printer %s: %s", j.Printer, err))
+			err = fmt.Errorf("Error forwarding data stream to printer %s: %s", j.Target, err)
 		}
 	}()
 
-	log.Infof("Passing data stream to printer: %s", j.Printer)
+	log.Infof("Passing data stream to printer: %s", j.Target)
 
-	log.Debugf("Opening input file: %s", j.File)
-	data, err := os.Open(j.File)
+	log.Debugf("Opening printer")
+	p, err := printer.Open(j.Target)
 	if err != nil {
 		return err
 	}
-	defer data.Close()
+	defer p.Close()
 
-	/*
-		log.Debugf("Opening printer")
-		p, err := printer.Open(j.Printer)
-		if err != nil {
-			return err
-		}
-		defer p.Close()
+	log.Debugf("Starting RAW document")
+	err = p.StartDocument(j.Title, "RAW")
+	if err != nil {
+		return err
+	}
+	defer p.EndDocument()
 
-		log.Debugf("Starting RAW document")
-		err = p.StartDocument(filepath.Base(j.File), "RAW")
-		if err != nil {
-			return err
-		}
-		defer p.EndDocument()
+	log.Debugf("Starting page")
+	err = p.StartPage()
+	if err != nil {
+		return err
+	}
+	defer p.EndPage()
 
-		log.Debugf("Starting page")
-		err = p.StartPage()
-		if err != nil {
-			return err
-		}
-		defer p.EndPage()
+	log.Debugf("Sending data")
+	bufferedWriter := bufio.NewWriter(p)
+	if err := j.spool(bufferedWriter); err != nil {
+		return err
+	}
+	return bufferedWriter.Flush()
+}
 
-		log.Debugf("Sending data")
-		bufferedWriter := bufio.NewWriter(p)
-		if err := j.spool(bufferedWriter); err != nil {
+func (j *PrintJob) process() error {
+	if err := j.inspect(); err != nil {
+		return err
+	}
+	if err := j.sanitize(); err != nil {
+		return err
+	}
+	if j.Language == PrintLanguagePostScript && !j.postScript && !j.ViaPDF && j.Target != app.TargetPDF {
+		log.Infof("Printer %s does not support PostScript, printing job %s via PDF", j.Target, j.Name)
+		j.ViaPDF = true
+	}
+	if j.Target == app.TargetPDF || j.ViaPDF {
+		if err := j.createPDF(j.pdfDir); err != nil {
 			return err
 		}
-		if err := bufferedWriter.Flush(); err != nil {
-			return err
+		if j.Target == app.TargetPDF {
+			return nil
 		}
-	*/
-	return nil
+		j.Language = PrintLanguagePDF
+	}
+	// the job only counts as done once its data has reached the printer
+	if j.Target == "" {
+		return fmt.Errorf("No printer to send job %s to", j.Name)
+	}
+	return j.sendToPrinter()
 }
 
 func (j *PrintJob) Process() {
-	j.inspect()
-	j.sanitize()
-	j.createPDF("w:\\")
-	j.sendToPrinter()
+	if j.Recovered {
+		log.Warnf("Processing job %s again after it was interrupted", j.Job.Name)
+	}
+	j.Processing()
+	parts, err := j.split()
+	if err != nil {
+		log.Errorf("Could not split job %s: %s", j.Job.Name, err)
+		j.Failed(err)
+		return
+	}
+	// the parts go to the printer in the order they were captured, a failed part does not
+	// keep the others from printing
+	pages := 0
+	for _, part := range parts {
+		if perr := part.process(); perr != nil {
+			log.Errorf("Could not process job %s (%s): %s", j.Job.Name, part.Name, perr)
+			if err == nil {
+				err = perr
+			}
+		}
+		pages += part.Pages
+	}
+	j.Pages = pages
+	j.SetPages(pages)
+	if err != nil {
+		j.Failed(err)
+		return
+	}
+	j.Done()
 }
@@ -0,0 +1,138 @@
+package printing
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/smuething/devicemonitor/pcl"
+	"github.com/smuething/devicemonitor/pjl"
+)
+
+// segment is a piece of a captured data stream between two job boundaries.
+type segment struct {
+	data    []byte
+	content bool // contains anything that would put something on paper
+	header  bool // opens a PJL job
+}
+
+// cutSegments cuts data in front of every UEC and, if atReset is set, also in front of every
+// printer reset (ESC E).
+func cutSegments(data []byte, atReset bool) []segment {
+	segments := make([]segment, 0)
+	start := 0
+	l := pcl.NewLexer(data)
+	for l.Scan() {
+		t := l.Token()
+		if len(segments) == 0 || t.Type == pcl.UEC || atReset && t.Is('E') {
+			if len(segments) > 0 {
+				segments[len(segments)-1].data = data[start:t.Offset]
+			}
+			segments = append(segments, segment{})
+			start = t.Offset
+		}
+		s := &segments[len(segments)-1]
+		switch t.Type {
+		case pcl.PJL:
+			if c, ok := pjl.ParseCommand(t.Raw); ok && (c.Name == pjl.Job || c.Name == pjl.Enter) {
+				s.header = true
+			}
+		case pcl.Text:
+			s.content = s.content || bytes.IndexFunc(t.Raw, func(r rune) bool { return r > ' ' }) >= 0
+		case pcl.Sequence:
+			for _, c := range t.Commands {
+				// raster graphics
+				s.content = s.content || c.Is("*bW") && len(c.Data) > 0
+			}
+		}
+	}
+	if len(segments) > 0 {
+		segments[len(segments)-1].data = data[start:]
+	}
+	return segments
+}
+
+// joinSegments merges the segments that do not contain anything to print into their neighbours:
+// headers that open a job go with the next segment, everything else (trailing resets and PJL EOJ
+// blocks) with the previous one.
+func joinSegments(segments []segment) []string {
+	parts := make([]string, 0, len(segments))
+	pending := ""
+	for _, segment := range segments {
+		if segment.content {
+			parts = append(parts, pending+string(segment.data))
+			pending = ""
+			continue
+		}
+		if pending == "" && len(parts) > 0 && !segment.header {
+			parts[len(parts)-1] += string(segment.data)
+		} else {
+			pending += string(segment.data)
+		}
+	}
+	if pending != "" {
+		if len(parts) > 0 {
+			parts[len(parts)-1] += pending
+		} else {
+			parts = append(parts, pending)
+		}
+	}
+	return parts
+}
+
+// splitData cuts a captured data stream into the jobs it contains. Jobs are separated by a UEC that
+// starts a new PJL job and, if atReset is set, also by printer resets (ESC E) between documents.
+func splitData(data []byte, atReset bool) []string {
+	return joinSegments(cutSegments(data, atReset))
+}
+
+// split cuts the job into the jobs that were captured together. If there is only a single job, it is
+// returned unchanged, otherwise each part is written to its own file next to the captured one.
+func (j *PrintJob) split() ([]*PrintJob, error) {
+	if !j.SplitJobs && !j.SplitAtReset {
+		return []*PrintJob{j}, nil
+	}
+
+	fi, err := os.Stat(j.input())
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() > MaxJobSize {
+		return nil, fmt.Errorf("Could not split print job %s: file size %d exceeds max job size %d", j.input(), fi.Size(), MaxJobSize)
+	}
+	rawData, err := ioutil.ReadFile(j.input())
+	if err != nil {
+		return nil, err
+	}
+
+	parts := splitData(rawData, j.SplitAtReset)
+	if len(parts) < 2 {
+		return []*PrintJob{j}, nil
+	}
+
+	log.Infof("Splitting job %s into %d jobs", j.Job.Name, len(parts))
+	basename := strings.TrimSuffix(j.File, filepath.Ext(j.File))
+	jobs := make([]*PrintJob, 0, len(parts))
+	for i, part := range parts {
+		file := fmt.Sprintf("%s-%d%s", basename, i+1, filepath.Ext(j.File))
+		if err := ioutil.WriteFile(file, []byte(part), 0644); err != nil {
+			return nil, err
+		}
+		name := fmt.Sprintf("%s-%d", j.Name, i+1)
+		if header, _ := pjl.Parse([]byte(part)); header.JobName() != "" {
+			name = header.JobName()
+		}
+		job := *j
+		job.Name = name
+		job.Title = fmt.Sprintf("%s (%d/%d)", j.Title, i+1, len(parts))
+		job.file = file
+		job.part = i + 1
+		jobs = append(jobs, &job)
+	}
+	return jobs, nil
+}
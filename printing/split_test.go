@@ -0,0 +1,91 @@
+package printing
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/smuething/devicemonitor/monitor"
+)
+
+func pjlJob(name string, data string) string {
+	return uec + "@PJL JOB NAME=\"" + name + "\"\r\n@PJL ENTER LANGUAGE=PCL\r\n" + data + uec + "@PJL EOJ NAME=\"" + name + "\"\r\n" + uec
+}
+
+func TestSplitData(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		atReset bool
+		want    []string
+	}{
+		{"empty", "", false, []string{}},
+		{"single job", "\x1bEone\x1bE", false, []string{"\x1bEone\x1bE"}},
+		{
+			name: "uec",
+			data: pjlJob("a", "\x1bEone\x1bE") + pjlJob("b", "\x1bEtwo\x1bE"),
+			want: []string{pjlJob("a", "\x1bEone\x1bE"), pjlJob("b", "\x1bEtwo\x1bE")},
+		},
+		{"resets ignored", "\x1bEone\x1bEtwo\x1bE", false, []string{"\x1bEone\x1bEtwo\x1bE"}},
+		{"resets", "\x1bEone\x1bEtwo\x1bE", true, []string{"\x1bEone", "\x1bEtwo\x1bE"}},
+		{
+			name:    "raster graphics",
+			data:    "\x1bE\x1b*b3Wabc\x1bE\x1b*b3Wdef",
+			atReset: true,
+			want:    []string{"\x1bE\x1b*b3Wabc", "\x1bE\x1b*b3Wdef"},
+		},
+		{"form feed at the end", "\x1bEone\x1bEtwo\x1bE\f", true, []string{"\x1bEone", "\x1bEtwo\x1bE\f"}},
+		{"form feed after the last job", pjlJob("a", "one") + "\f", false, []string{pjlJob("a", "one") + "\f"}},
+		{
+			name: "form feed after uec",
+			data: pjlJob("a", "one") + "\f" + pjlJob("b", "two"),
+			want: []string{pjlJob("a", "one") + "\f", pjlJob("b", "two")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitData([]byte(tt.data), tt.atReset); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "pj-test.prn")
+	data := pjlJob("a", "\x1bEone\x1bE") + "\x1bEtwo\x1bE"
+	if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	j := &PrintJob{Job: &monitor.Job{File: file}, Name: "pj-test", Title: "Rechnung", SplitJobs: true}
+
+	jobs, err := j.split()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		name  string
+		title string
+		file  string
+		data  string
+	}{
+		// the UEC that closes the first job also starts the second one
+		{"a", "Rechnung (1/2)", "pj-test-1.prn", strings.TrimSuffix(pjlJob("a", "\x1bEone\x1bE"), uec)},
+		{"pj-test-2", "Rechnung (2/2)", "pj-test-2.prn", uec + "\x1bEtwo\x1bE"},
+	}
+	if len(jobs) != len(want) {
+		t.Fatalf("got %d jobs, want %d", len(jobs), len(want))
+	}
+	for i, w := range want {
+		got := jobs[i]
+		if got.Name != w.name || got.Title != w.title || filepath.Base(got.input()) != w.file || got.part != i+1 {
+			t.Errorf("job %d: got %s %q in %s, want %s %q in %s", i+1, got.Name, got.Title, got.input(), w.name, w.title, w.file)
+		}
+		if data, err := ioutil.ReadFile(got.input()); err != nil || string(data) != w.data {
+			t.Errorf("job %d: got data %q, %v", i+1, data, err)
+		}
+	}
+}
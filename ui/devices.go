@@ -0,0 +1,332 @@
+package ui
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/smuething/devicemonitor/app"
+	"github.com/smuething/devicemonitor/monitor"
+	"github.com/smuething/devicemonitor/printing"
+)
+
+// how long we wait for a job in flight before removing its device anyway
+const drainTimeout = 30 * time.Second
+
+func sortedDeviceConfigs(config *app.Configuration) []app.DeviceConfig {
+	deviceConfigs := make([]app.DeviceConfig, 0)
+	for _, dc := range config.Devices {
+		deviceConfigs = append(deviceConfigs, dc)
+	}
+	sort.Slice(deviceConfigs, func(i, j int) bool {
+		return deviceConfigs[i].Pos < deviceConfigs[j].Pos
+	})
+	return deviceConfigs
+}
+
+// configureQueue applies the settings of a device that can be changed while the queue is running.
+func configureQueue(queue *monitor.Queue, dc *app.DeviceConfig) error {
+	detector, err := monitor.NewJobCompletionDetector(dc.Completion, dc.CompletionFallback)
+	if err != nil {
+		return fmt.Errorf("Invalid job completion settings for device %s: %s", dc.Device, err)
+	}
+	validator, err := monitor.NewJobValidator(dc.Validation)
+	if err != nil {
+		return fmt.Errorf("Invalid job validation settings for device %s: %s", dc.Device, err)
+	}
+	duplicates := monitor.DuplicatePolicy{}
+	if dc.Duplicates != nil {
+		duplicates.Window = dc.Duplicates.Window
+		action := dc.Duplicates.Action
+		if action == "" {
+			action = "hold"
+		}
+		duplicates.Action, err = monitor.ParseDuplicateAction(action)
+		if err != nil {
+			return fmt.Errorf("Invalid duplicate settings for device %s: %s", dc.Device, err)
+		}
+		if dc.Duplicates.Sanitized {
+			duplicates.Normalize = printing.SanitizeForComparison
+		}
+	}
+	queue.SetCompletionDetector(detector)
+	queue.SetValidator(validator)
+	queue.SetDuplicatePolicy(duplicates)
+	queue.SetName(dc.Name)
+	queue.SetTimeout(dc.Timeout)
+	queue.SetExtendedTimeout(dc.ExtendedTimeout)
+	queue.SetExtendTimeout(dc.ExtendTimeout)
+	queue.SetAdaptiveTimeout(dc.AdaptiveTimeout)
+	queue.SetHold(dc.Hold)
+	return nil
+}
+
+func serialSettings(dc *app.DeviceConfig) (monitor.SerialSettings, error) {
+	parity, err := monitor.ParseParity(dc.Serial.Parity)
+	if err != nil {
+		return monitor.SerialSettings{}, err
+	}
+	port := dc.Serial.Port
+	if port == "" {
+		port = dc.Device
+	}
+	return monitor.SerialSettings{
+		Port:     port,
+		Baud:     dc.Serial.Baud,
+		DataBits: dc.Serial.DataBits,
+		Parity:   parity,
+		StopBits: dc.Serial.StopBits,
+		XonXoff:  dc.Serial.XonXoff,
+	}, nil
+}
+
+// portType determines the port of a device from its configuration.
+func portType(dc *app.DeviceConfig) (monitor.PortType, error) {
+	switch {
+	case dc.Address != "":
+		return monitor.PortTCP, nil
+	case dc.Serial != nil:
+		return monitor.PortSerial, nil
+	default:
+		return monitor.ParsePortType(dc.Port)
+	}
+}
+
+// queueMatches reports whether a running queue captures the device as configured. The port and
+// the line settings of serial ports can only be applied by adding a new queue.
+func queueMatches(queue *monitor.Queue, dc *app.DeviceConfig) bool {
+	if dc.File != filepath.Base(queue.File) || dc.Address != queue.Address {
+		return false
+	}
+	port, err := portType(dc)
+	if err != nil || port != queue.Port {
+		return false
+	}
+	if port == monitor.PortSerial {
+		settings, err := serialSettings(dc)
+		return err == nil && settings.Equal(queue.Serial)
+	}
+	return true
+}
+
+func addQueue(m *monitor.Monitor, dc *app.DeviceConfig) error {
+	var queue *monitor.Queue
+	var err error
+	if dc.Address != "" {
+		queue, err = m.AddTCPPort(dc.Device, dc.File, dc.Address, dc.Name)
+	} else if dc.Serial != nil {
+		var settings monitor.SerialSettings
+		settings, err = serialSettings(dc)
+		if err == nil {
+			queue, err = m.AddSerialPort(dc.Device, dc.File, dc.Name, dc.Timeout, settings)
+		}
+	} else {
+		var port monitor.PortType
+		port, err = portType(dc)
+		if err == nil {
+			queue, err = m.AddDevice(dc.Device, dc.File, dc.Name, dc.Timeout, port)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("Could not add device %s: %s", dc.Device, err)
+	}
+	if err = configureQueue(queue, dc); err != nil {
+		ctx, cancel := app.ContextWithTimeout(drainTimeout, true)
+		defer cancel()
+		m.RemoveDevice(ctx, dc.Device)
+		return err
+	}
+	return nil
+}
+
+// addDeviceMenu creates the tray menu for a device and forwards the changes made in the menu
+// to the configuration. Must be called on the UI thread.
+func addDeviceMenu(m *monitor.Monitor, tray *Tray, dc app.DeviceConfig) error {
+	err := tray.addDeviceMenu(&dc)
+	if err != nil {
+		return fmt.Errorf("Could not create menu for device %s: %s", dc.Device, err)
+	}
+	device := tray.devices[dc.Device]
+	app.Go(func() {
+		for target := range device.Selected() {
+			app.SetConfigByPath(target, "Devices", dc.Device, "target")
+			func() {
+				config := app.Config()
+				config.Lock()
+				defer config.Unlock()
+				device.ResetJobTypes(config.Printer(target), config.Devices[strings.ToLower(dc.Device)].JobConfigs[strings.ToLower(target)])
+			}()
+		}
+	})
+	app.Go(func() {
+		for value := range device.ExtendTimeout() {
+			app.SetConfigByPath(value, "devices", dc.Device, "extend_timeout")
+			if queue := m.Queue(dc.Device); queue != nil {
+				queue.SetExtendTimeout(value)
+				log.Infof("Timeout for device %s is now %s", dc.Device, queue.Timeout())
+			}
+		}
+	})
+	app.Go(func() {
+		for value := range device.PrintViaPDF() {
+			app.SetConfigByPath(value, "devices", dc.Device, "print_via_pdf")
+		}
+	})
+	app.Go(func() {
+		for value := range device.JobConfig() {
+			app.SetConfigByPath(value.JobConfig, "devices", dc.Device, "job_configs", strings.ToLower(value.Printer))
+		}
+	})
+	app.Go(func() {
+		for value := range device.Hold() {
+			app.SetConfigByPath(value, "devices", dc.Device, "hold")
+			if queue := m.Queue(dc.Device); queue != nil {
+				queue.SetHold(value)
+			}
+			updateHeldJobs(m, tray)
+		}
+	})
+	app.Go(func() {
+		for msg := range device.JobActions() {
+			var err error
+			switch msg.Action {
+			case ReleaseJob:
+				err = m.ReleaseJob(msg.Job)
+			case ReleaseAllJobs:
+				for _, j := range heldJobs(m, dc.Device) {
+					if err = m.ReleaseJob(j.Name); err != nil {
+						break
+					}
+				}
+			case CancelJob:
+				err = m.CancelJob(msg.Job)
+			case MoveJob:
+				err = m.MoveJob(msg.Job, msg.Device)
+			}
+			if err != nil {
+				log.Error(err)
+			}
+			updateHeldJobs(m, tray)
+		}
+	})
+	return nil
+}
+
+func heldJobs(m *monitor.Monitor, device string) []HeldJob {
+	jobs := make([]HeldJob, 0)
+	for _, j := range m.QueuedJobs() {
+		if j.Held && strings.EqualFold(j.Device, device) {
+			jobs = append(jobs, HeldJob{Name: j.Name, Time: j.Time})
+		}
+	}
+	return jobs
+}
+
+// updateHeldJobs refreshes the menus of held jobs of all devices.
+func updateHeldJobs(m *monitor.Monitor, tray *Tray) {
+	devices := make([]string, 0)
+	for _, queue := range m.Queues() {
+		devices = append(devices, queue.Device)
+	}
+	sort.Strings(devices)
+
+	held := make(map[string][]HeldJob)
+	targets := make(map[string][]string)
+	for _, device := range devices {
+		held[device] = heldJobs(m, device)
+		for _, target := range devices {
+			if target != device {
+				targets[device] = append(targets[device], target)
+			}
+		}
+	}
+
+	tray.mw.Synchronize(func() {
+		for device, menu := range tray.devices {
+			menu.SetHeldJobs(held[device], targets[device])
+		}
+	})
+}
+
+// syncDevices brings the monitored devices and their tray menus in line with the current
+// configuration. Devices that have been removed are drained before they are unbound.
+func syncDevices(m *monitor.Monitor, tray *Tray) {
+	config := app.Config()
+	config.Lock()
+	deviceConfigs := sortedDeviceConfigs(config)
+	config.Unlock()
+
+	configured := make(map[string]app.DeviceConfig)
+	for _, dc := range deviceConfigs {
+		configured[strings.ToLower(dc.Device)] = dc
+	}
+
+	for _, queue := range m.Queues() {
+		dc, found := configured[strings.ToLower(queue.Device)]
+		if found && queueMatches(queue, &dc) {
+			continue
+		}
+		device := queue.Device
+		if found {
+			log.Infof("The port of device %s has been changed, it will be added again", device)
+		} else {
+			log.Infof("Device %s has been removed from the configuration", device)
+		}
+		tray.mw.Synchronize(func() {
+			if err := tray.removeDeviceMenu(device); err != nil {
+				log.Error(err)
+			}
+		})
+		ctx, cancel := app.ContextWithTimeout(drainTimeout, true)
+		err := m.RemoveDevice(ctx, device)
+		cancel()
+		if err != nil {
+			log.Error(err)
+		}
+	}
+
+	for i := range deviceConfigs {
+		dc := deviceConfigs[i]
+		if queue := m.Queue(dc.Device); queue != nil {
+			if err := configureQueue(queue, &dc); err != nil {
+				log.Error(err)
+			}
+			tray.mw.Synchronize(func() {
+				tray.updateDeviceMenu(&dc)
+			})
+			continue
+		}
+		log.Infof("Device %s has been added to the configuration", dc.Device)
+		if err := addQueue(m, &dc); err != nil {
+			log.Error(err)
+			continue
+		}
+		tray.mw.Synchronize(func() {
+			if err := addDeviceMenu(m, tray, dc); err != nil {
+				log.Error(err)
+			}
+		})
+	}
+}
+
+// notifyDuplicate tells the user that a job was held back or dropped because it was printed twice.
+func notifyDuplicate(tray *Tray, e monitor.Event) {
+	config := app.Config()
+	config.Lock()
+	dc := config.Devices[strings.ToLower(e.Device)]
+	config.Unlock()
+
+	message := "Der Auftrag wurde angehalten und kann im Gerätemenü freigegeben werden."
+	if dc.Duplicates != nil && strings.EqualFold(dc.Duplicates.Action, "drop") {
+		message = "Der Auftrag wurde verworfen."
+	}
+	tray.mw.Synchronize(func() {
+		err := tray.ShowInfo(fmt.Sprintf("Doppelter Druckauftrag auf %s", e.Device), message)
+		if err != nil {
+			log.Error(err)
+		}
+	})
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/smuething/devicemonitor/app"
 
@@ -25,6 +27,27 @@ type JobConfigMsg struct {
 	JobConfig string
 }
 
+type JobAction int
+
+const (
+	ReleaseJob JobAction = iota
+	ReleaseAllJobs
+	CancelJob
+	MoveJob
+)
+
+type JobActionMsg struct {
+	Action JobAction
+	Job    string
+	Device string // target of MoveJob
+}
+
+// HeldJob is an entry of the menu of held jobs.
+type HeldJob struct {
+	Name string
+	Time time.Time
+}
+
 type DeviceMenu struct {
 	*walk.Menu
 	action                    *walk.Action
@@ -32,6 +55,8 @@ type DeviceMenu struct {
 	extendTimeout             chan bool
 	printViaPDF               chan bool
 	jobConfig                 chan JobConfigMsg
+	hold                      chan bool
+	jobAction                 chan JobActionMsg
 	active                    *walk.Action
 	entries                   map[string]*walk.Action
 	extendTimeoutAction       *walk.Action
@@ -39,6 +64,10 @@ type DeviceMenu struct {
 	jobConfigMenuAction       *walk.Action
 	jobConfigMenu             *walk.Menu
 	activeJobConfigMenuAction *walk.Action
+	holdAction                *walk.Action
+	heldJobsMenuAction        *walk.Action
+	heldJobsMenu              *walk.Menu
+	closeOnce                 sync.Once
 }
 
 func NewDeviceMenu() (*DeviceMenu, error) {
@@ -48,6 +77,8 @@ func NewDeviceMenu() (*DeviceMenu, error) {
 		extendTimeout: make(chan bool),
 		printViaPDF:   make(chan bool),
 		jobConfig:     make(chan JobConfigMsg),
+		hold:          make(chan bool),
+		jobAction:     make(chan JobActionMsg),
 		entries:       make(map[string]*walk.Action),
 	}
 
@@ -76,6 +107,107 @@ func (dm *DeviceMenu) JobConfig() <-chan JobConfigMsg {
 	return dm.jobConfig
 }
 
+func (dm *DeviceMenu) Hold() <-chan bool {
+	return dm.hold
+}
+
+func (dm *DeviceMenu) JobActions() <-chan JobActionMsg {
+	return dm.jobAction
+}
+
+// close closes the channels of the menu to stop the goroutines listening on them
+func (dm *DeviceMenu) close() {
+	dm.closeOnce.Do(func() {
+		close(dm.selected)
+		close(dm.extendTimeout)
+		close(dm.printViaPDF)
+		close(dm.jobConfig)
+		close(dm.hold)
+		close(dm.jobAction)
+	})
+}
+
+func (dm *DeviceMenu) sendJobAction(msg JobActionMsg) {
+	select {
+	case dm.jobAction <- msg:
+	default:
+		// ignore if no receiver
+	}
+}
+
+// SetHeldJobs rebuilds the menu of held jobs, devices are the targets jobs can be moved to.
+func (dm *DeviceMenu) SetHeldJobs(jobs []HeldJob, devices []string) {
+	dm.heldJobsMenu.Actions().Clear()
+	if len(jobs) == 0 {
+		dm.heldJobsMenuAction.SetText("Keine angehaltenen Aufträge")
+		dm.heldJobsMenuAction.SetEnabled(false)
+		return
+	}
+	dm.heldJobsMenuAction.SetText(fmt.Sprintf("Angehaltene Aufträge (%d)", len(jobs)))
+	dm.heldJobsMenuAction.SetEnabled(true)
+
+	action := walk.NewAction()
+	action.SetText("Alle freigeben")
+	action.Triggered().Attach(func() {
+		dm.sendJobAction(JobActionMsg{Action: ReleaseAllJobs})
+	})
+	dm.heldJobsMenu.Actions().Add(action)
+	dm.heldJobsMenu.Actions().Add(walk.NewSeparatorAction())
+
+	for _, job := range jobs {
+		name := job.Name
+		jobMenu, err := walk.NewMenu()
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+
+		action := walk.NewAction()
+		action.SetText("Freigeben")
+		action.Triggered().Attach(func() {
+			dm.sendJobAction(JobActionMsg{Action: ReleaseJob, Job: name})
+		})
+		jobMenu.Actions().Add(action)
+
+		action = walk.NewAction()
+		action.SetText("Abbrechen")
+		action.Triggered().Attach(func() {
+			dm.sendJobAction(JobActionMsg{Action: CancelJob, Job: name})
+		})
+		jobMenu.Actions().Add(action)
+
+		if len(devices) > 0 {
+			moveMenu, err := walk.NewMenu()
+			if err != nil {
+				log.Error(err)
+				continue
+			}
+			for _, device := range devices {
+				device := device
+				action := walk.NewAction()
+				action.SetText(device)
+				action.Triggered().Attach(func() {
+					dm.sendJobAction(JobActionMsg{Action: MoveJob, Job: name, Device: device})
+				})
+				moveMenu.Actions().Add(action)
+			}
+			moveAction, err := jobMenu.Actions().AddMenu(moveMenu)
+			if err != nil {
+				log.Error(err)
+				continue
+			}
+			moveAction.SetText("Verschieben nach")
+		}
+
+		jobAction, err := dm.heldJobsMenu.Actions().AddMenu(jobMenu)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		jobAction.SetText(job.Time.Format("02.01. 15:04:05"))
+	}
+}
+
 func (dm *DeviceMenu) ResetJobTypes(config *app.PrinterConfig, current string) {
 	dm.jobConfigMenu.Actions().Clear()
 	if config == nil || len(config.Jobs) == 0 {
@@ -130,14 +262,17 @@ func (dm *DeviceMenu) ResetJobTypes(config *app.PrinterConfig, current string) {
 type Tray struct {
 	*walk.NotifyIcon
 
-	mw      *walk.MainWindow
-	devices map[string]*DeviceMenu
+	mw        *walk.MainWindow
+	devices   map[string]*DeviceMenu
+	noDevices *walk.Action
+	reload    chan struct{}
 }
 
 func NewTray(mainWindow *walk.MainWindow) (*Tray, error) {
 	tray := &Tray{
 		mw:      mainWindow,
 		devices: make(map[string]*DeviceMenu),
+		reload:  make(chan struct{}),
 	}
 
 	var err error
@@ -149,6 +284,10 @@ func NewTray(mainWindow *walk.MainWindow) (*Tray, error) {
 	return tray, tray.setup()
 }
 
+func (tray *Tray) Reload() <-chan struct{} {
+	return tray.reload
+}
+
 func (tray *Tray) setup() error {
 
 	tray.SetToolTip("Druckverwaltung")
@@ -167,16 +306,25 @@ func (tray *Tray) finalize() error {
 	var action *walk.Action
 
 	if len(tray.devices) == 0 {
-		action = walk.NewAction()
-		action.SetText("Keine Geräte")
-		action.SetCheckable(false)
-		action.SetDefault(true)
-		tray.ContextMenu().Actions().Add(action)
+		tray.addNoDevicesAction()
 	}
 
 	action = walk.NewSeparatorAction()
 	tray.ContextMenu().Actions().Add(action)
 	action = walk.NewAction()
+	action.SetText("Konfiguration neu laden")
+	action.Triggered().Attach(func() {
+		select {
+		case tray.reload <- struct{}{}:
+		default:
+			// ignore if no receiver or reload already running
+		}
+	})
+	tray.ContextMenu().Actions().Add(action)
+	tray.mw.Disposing().Attach(func() {
+		close(tray.reload)
+	})
+	action = walk.NewAction()
 	action.SetText("Über Druckverwaltung")
 	tray.ContextMenu().Actions().Add(action)
 	action = walk.NewAction()
@@ -191,6 +339,15 @@ func (tray *Tray) finalize() error {
 	return nil
 }
 
+func (tray *Tray) addNoDevicesAction() {
+	action := walk.NewAction()
+	action.SetText("Keine Geräte")
+	action.SetCheckable(false)
+	action.SetDefault(true)
+	tray.ContextMenu().Actions().Insert(0, action)
+	tray.noDevices = action
+}
+
 func containsString(stack []string, needle string) bool {
 	for _, hay := range stack {
 		if hay == needle {
@@ -218,14 +375,14 @@ func (tray *Tray) addDeviceMenu(config *app.DeviceConfig) (err error) {
 	}
 
 	options := []deviceTarget{
-		{name: "PDF", active: "PDF" == target},
-		{name: "Drucker wählen", active: "Drucker wählen" == target},
+		{name: app.TargetPDF, active: app.TargetPDF == target},
+		{name: app.TargetChoose, active: app.TargetChoose == target},
 		{separator: true},
 	}
 
 	blacklist := []string{
-		"PDF",
-		"Drucker wählen",
+		app.TargetPDF,
+		app.TargetChoose,
 	}
 
 	printers, _ := printer.ReadNames()
@@ -327,14 +484,44 @@ func (tray *Tray) addDeviceMenu(config *app.DeviceConfig) (err error) {
 	})
 	menu.Actions().Add(action)
 
+	action = walk.NewSeparatorAction()
+	menu.Actions().Add(action)
+
+	action = walk.NewAction()
+	action.SetText("Aufträge anhalten")
+	action.SetCheckable(true)
+	action.SetChecked(config.Hold)
+	menu.holdAction = action
+	action.Triggered().Attach(func() {
+		action := menu.holdAction
+		select {
+		case menu.hold <- action.Checked():
+		default:
+			// ignore if no receiver
+		}
+	})
+	menu.Actions().Add(action)
+
+	menu.heldJobsMenu, err = walk.NewMenu()
+	if err != nil {
+		return err
+	}
+	menu.heldJobsMenuAction, err = menu.Actions().AddMenu(menu.heldJobsMenu)
+	if err != nil {
+		return err
+	}
+	menu.SetHeldJobs(nil, nil)
+
 	tray.mw.Disposing().Attach(func() {
 		// avoid leaking channels and stalling listening goroutines
-		close(menu.selected)
-		close(menu.extendTimeout)
-		close(menu.printViaPDF)
-		close(menu.jobConfig)
+		menu.close()
 	})
 
+	if tray.noDevices != nil {
+		tray.ContextMenu().Actions().Remove(tray.noDevices)
+		tray.noDevices = nil
+	}
+
 	menu.action, err = tray.ContextMenu().Actions().InsertMenu(len(tray.devices), menu.Menu)
 	if err != nil {
 		return err
@@ -345,3 +532,25 @@ func (tray *Tray) addDeviceMenu(config *app.DeviceConfig) (err error) {
 	return err
 
 }
+
+func (tray *Tray) updateDeviceMenu(config *app.DeviceConfig) {
+	if menu, found := tray.devices[config.Device]; found {
+		menu.action.SetText(fmt.Sprintf("%s (%s)", config.Device, config.Name))
+		menu.holdAction.SetChecked(config.Hold)
+	}
+}
+
+func (tray *Tray) removeDeviceMenu(device string) error {
+	menu, found := tray.devices[device]
+	if !found {
+		return fmt.Errorf("No menu for device %s", device)
+	}
+	delete(tray.devices, device)
+	menu.close()
+	err := tray.ContextMenu().Actions().Remove(menu.action)
+	menu.Dispose()
+	if len(tray.devices) == 0 {
+		tray.addNoDevicesAction()
+	}
+	return err
+}
@@ -8,8 +8,6 @@ import (
 	"os/signal"
 	"runtime"
 	"runtime/debug"
-	"sort"
-	"strings"
 	"syscall"
 
 	"github.com/smuething/devicemonitor/printing"
@@ -17,6 +15,8 @@ import (
 	"github.com/lxn/walk"
 	log "github.com/sirupsen/logrus"
 	"github.com/smuething/devicemonitor/app"
+	"github.com/smuething/devicemonitor/ipp"
+	"github.com/smuething/devicemonitor/lpd"
 	"github.com/smuething/devicemonitor/monitor"
 )
 
@@ -71,79 +71,126 @@ func RunUI() {
 		defer config.Unlock()
 		m = monitor.NewMonitor(config.Paths.SpoolDir, nil)
 
-		deviceConfigs := make([]app.DeviceConfig, 0)
-		for _, dc := range config.Devices {
-			deviceConfigs = append(deviceConfigs, dc)
+		if config.Queue.Capacity > 0 || config.Queue.Overflow != "" {
+			capacity := config.Queue.Capacity
+			if capacity == 0 {
+				capacity = monitor.DefaultQueueCapacity
+			}
+			overflow, err := monitor.ParseOverflowPolicy(config.Queue.Overflow)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err = m.SetQueueLimits(capacity, overflow); err != nil {
+				log.Fatal(err)
+			}
 		}
-		sort.Slice(deviceConfigs, func(i, j int) bool {
-			return deviceConfigs[i].Pos < deviceConfigs[j].Pos
-		})
 
-		for i := range deviceConfigs {
-			// make sure we get separate variables for the closure down below
-			dc := deviceConfigs[i]
-			_, err = m.AddDevice(dc.Device, dc.File, dc.Name, dc.Timeout)
+		if config.Watcher.Kind != "" {
+			watcher, err := monitor.NewWatcher(config.Watcher.Kind, config.Watcher.PollInterval)
 			if err != nil {
-				log.Fatalf("Could not add device: %s", dc.Device)
+				log.Fatal(err)
 			}
-			err = tray.addDeviceMenu(&dc)
-			if err != nil {
-				log.Fatalf("Could not create menu for device: %s", dc.Device)
+			m.SetWatcher(watcher)
+		}
+
+		m.SetRetentionPolicy(monitor.RetentionPolicy{
+			MaxAge:       config.Retention.MaxAge,
+			FailedMaxAge: config.Retention.FailedMaxAge,
+			MaxTotalSize: config.Retention.MaxTotalSize,
+			KeepLast:     config.Retention.KeepLast,
+			Interval:     config.Retention.Interval,
+		})
+
+		for _, dc := range sortedDeviceConfigs(config) {
+			if err := addQueue(m, &dc); err != nil {
+				log.Fatal(err)
 			}
+			if err := addDeviceMenu(m, tray, dc); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+	}()
+
+	tray.finalize()
+
+	var lpdServer *lpd.Server
+	func() {
+		config.Lock()
+		defer config.Unlock()
+		if config.LPD.Enable {
+			lpdServer = lpd.NewServer(m, config.LPD.Queues)
+			address := config.LPD.Address
 			app.Go(func() {
-				device := tray.devices[dc.Device]
-				for target := range device.Selected() {
-					app.SetConfigByPath(target, "Devices", dc.Device, "target")
-					func() {
-						config := app.Config()
-						config.Lock()
-						defer config.Unlock()
-						device.ResetJobTypes(config.Printer(target), config.Devices[strings.ToLower(dc.Device)].JobConfigs[strings.ToLower(target)])
-					}()
-				}
-			})
-			app.Go(func() {
-				device := tray.devices[dc.Device]
-				for value := range device.ExtendTimeout() {
-					app.SetConfigByPath(value, "devices", dc.Device, "extend_timeout")
-				}
-			})
-			app.Go(func() {
-				device := tray.devices[dc.Device]
-				for value := range device.PrintViaPDF() {
-					app.SetConfigByPath(value, "devices", dc.Device, "print_via_pdf")
+				if err := lpdServer.ListenAndServe(app.Context(), address); err != nil {
+					log.Errorf("Could not run LPD server: %s", err)
 				}
 			})
+		}
+		if config.IPP.Enable {
+			ippServer := ipp.NewServer(m)
+			address := config.IPP.Address
 			app.Go(func() {
-				device := tray.devices[dc.Device]
-				for value := range device.JobConfig() {
-					app.SetConfigByPath(value.JobConfig, "devices", dc.Device, "job_configs", strings.ToLower(value.Printer))
+				if err := ippServer.ListenAndServe(app.Context(), address); err != nil {
+					log.Errorf("Could not run IPP server: %s", err)
 				}
 			})
-
 		}
-
 	}()
 
-	tray.finalize()
+	app.OnReload(func() {
+		syncDevices(m, tray)
+		if lpdServer != nil {
+			config := app.Config()
+			config.Lock()
+			defer config.Unlock()
+			lpdServer.SetQueues(config.LPD.Queues)
+		}
+	})
+
+	app.Go(func() {
+		for range tray.Reload() {
+			if err := app.ReloadConfig(); err != nil {
+				log.Errorf("Could not reload configuration: %s", err)
+			}
+		}
+	})
 
 	app.Go(func() {
 		for mj := range m.Jobs() {
-			j := printing.NewPrintJob(
-				mj,
-				"test",
-				"test",
-				printing.PrintLanguagePDF,
-				false,
-				0,
-			)
+			j, err := printing.NewPrintJob(mj)
+			if err != nil {
+				log.Errorf("Could not create print job for job %s: %s", mj.Name, err)
+				mj.Failed(err)
+				continue
+			}
 			app.Go(j.Process)
 		}
 	})
 
+	events := m.Subscribe()
 	app.Go(func() {
 		previous := 0
-		for active := range m.Spooling() {
+		active := 0
+		for e := range events.Events() {
+			log.Debug(e)
+			switch e.Type {
+			case monitor.JobStarted, monitor.JobCompleted:
+				// jobs that are dropped or interrupted never complete, so we do not count
+				// the events ourselves
+				active = m.SpoolingJobs()
+			case monitor.JobDropped:
+				updateHeldJobs(m, tray)
+				active = m.SpoolingJobs()
+			case monitor.JobHeld:
+				updateHeldJobs(m, tray)
+				continue
+			case monitor.JobDuplicate:
+				notifyDuplicate(tray, e)
+				continue
+			default:
+				continue
+			}
 			if active != previous {
 				id := ""
 				if active > 0 && previous == 0 {